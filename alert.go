@@ -0,0 +1,304 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"m7s.live/engine/v4/config"
+	"m7s.live/engine/v4/log"
+	"m7s.live/plugin/exporter/v4/collector"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AlertConfig struct {
+	Interval time.Duration //规则评估间隔
+	Webhooks string        //告警通知地址，多个用逗号分隔，兼容 Alertmanager 的 /api/v2/alerts
+	Rules    config.Config //告警规则，名称: 表达式
+}
+
+func (c AlertConfig) validate() error {
+	return checkPositive("interval", c.Interval)
+}
+
+const (
+	alertPending = "pending"
+	alertFiring  = "firing"
+)
+
+// alertRuleRegexp 规则表达式，比如 monibuca_disk_used_percent{path="/"} > 90 for 5m
+var alertRuleRegexp = regexp.MustCompile(`^(.+)\s*(>=|<=|==|!=|>|<)\s*(\S+?)(?:\s+for\s+(\S+))?\s*$`)
+
+type alertRule struct {
+	name      string
+	expr      string
+	selector  *selector
+	op        string
+	threshold float64
+	duration  time.Duration
+}
+
+func parseAlertRule(name, expr string) (*alertRule, error) {
+	m := alertRuleRegexp.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, fmt.Errorf("invalid alert rule %s: %q", name, expr)
+	}
+	rule := &alertRule{name: name, expr: expr, op: m[2]}
+	var err error
+	if rule.selector, err = parseSelector(m[1]); err != nil {
+		return nil, fmt.Errorf("invalid alert rule %s: %w", name, err)
+	}
+	if rule.threshold, err = strconv.ParseFloat(m[3], 64); err != nil {
+		return nil, fmt.Errorf("invalid alert rule %s threshold %q", name, m[3])
+	}
+	if m[4] != "" {
+		if rule.duration, err = time.ParseDuration(m[4]); err != nil {
+			return nil, fmt.Errorf("invalid alert rule %s duration %q", name, m[4])
+		}
+	}
+	return rule, nil
+}
+
+func (r *alertRule) matches(v float64) bool {
+	switch r.op {
+	case ">":
+		return v > r.threshold
+	case ">=":
+		return v >= r.threshold
+	case "<":
+		return v < r.threshold
+	case "<=":
+		return v <= r.threshold
+	case "==":
+		return v == r.threshold
+	default:
+		return v != r.threshold
+	}
+}
+
+type alert struct {
+	Labels   map[string]string `json:"labels"`
+	State    string            `json:"state"`
+	ActiveAt time.Time         `json:"activeAt"`
+	Value    float64           `json:"value"`
+	Expr     string            `json:"expr"`
+	labels   []labelPair
+}
+
+// amAlert Alertmanager /api/v2/alerts 接口的告警格式
+type amAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+}
+
+// alerter 定时按规则评估采集结果，维护 pending、firing 状态，并把 firing 和 resolved 的告警发送到 webhook
+type alerter struct {
+	conf     AlertConfig
+	rules    []*alertRule
+	webhooks []string
+	g        prometheus.Gatherer
+	client   *http.Client
+
+	mu     sync.RWMutex
+	alerts map[string]map[string]*alert //规则名 -> 序列 -> 告警
+}
+
+func newAlerter(conf AlertConfig, g prometheus.Gatherer) (*alerter, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	a := &alerter{
+		conf:   conf,
+		g:      g,
+		client: &http.Client{Timeout: 10 * time.Second},
+		alerts: make(map[string]map[string]*alert),
+	}
+	for name, expr := range conf.Rules {
+		s, ok := expr.(string)
+		if !ok {
+			return nil, fmt.Errorf("alert rule %s is not a string", name)
+		}
+		rule, err := parseAlertRule(name, s)
+		if err != nil {
+			return nil, err
+		}
+		a.rules = append(a.rules, rule)
+		a.alerts[name] = make(map[string]*alert)
+	}
+	sort.Slice(a.rules, func(i, j int) bool { return a.rules[i].name < a.rules[j].name })
+	for _, webhook := range strings.Split(conf.Webhooks, ",") {
+		if webhook = strings.TrimSpace(webhook); webhook != "" {
+			a.webhooks = append(a.webhooks, webhook)
+		}
+	}
+	return a, nil
+}
+
+// run 按评估间隔定时评估规则，直到 ctx 取消
+func (a *alerter) run(ctx context.Context) {
+	ticker := time.NewTicker(a.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			mfs, err := a.g.Gather()
+			if err != nil {
+				log.Warn("Exporter alert gather err: ", err)
+			}
+			if notify := a.eval(flatten(mfs, now), now); len(notify) > 0 {
+				a.send(ctx, notify)
+			}
+		}
+	}
+}
+
+// eval 评估所有规则，返回需要通知的告警，firing 的告警每次都会重新发送，resolved 的只发送一次
+func (a *alerter) eval(samples []sample, now time.Time) []amAlert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var notify []amAlert
+	for _, rule := range a.rules {
+		active := a.alerts[rule.name]
+		seen := make(map[string]bool)
+		for _, s := range samples {
+			if !rule.selector.matches(s.Name, s.Labels) || math.IsNaN(s.Value) || !rule.matches(s.Value) {
+				continue
+			}
+			key := seriesKey(s.Name, s.Labels)
+			seen[key] = true
+			al, ok := active[key]
+			if !ok {
+				al = &alert{State: alertPending, ActiveAt: now, Expr: rule.expr, labels: s.Labels}
+				al.Labels = map[string]string{"alertname": rule.name}
+				for _, l := range s.Labels {
+					al.Labels[l.Name] = l.Value
+				}
+				active[key] = al
+			}
+			al.Value = s.Value
+			if al.State == alertPending && now.Sub(al.ActiveAt) >= rule.duration {
+				al.State = alertFiring
+				log.Warnf("Exporter alert %s firing: %s, value %v", rule.name, rule.expr, al.Value)
+			}
+			if al.State == alertFiring {
+				notify = append(notify, a.amAlert(al, now.Add(3*a.conf.Interval)))
+			}
+		}
+		for key, al := range active {
+			if seen[key] {
+				continue
+			}
+			if al.State == alertFiring {
+				log.Infof("Exporter alert %s resolved", rule.name)
+				notify = append(notify, a.amAlert(al, now))
+			}
+			delete(active, key)
+		}
+	}
+	return notify
+}
+
+func (a *alerter) amAlert(al *alert, endsAt time.Time) amAlert {
+	return amAlert{
+		Labels: al.Labels,
+		Annotations: map[string]string{
+			"expr":  al.Expr,
+			"value": formatFloat(al.Value),
+		},
+		StartsAt:     al.ActiveAt,
+		EndsAt:       endsAt,
+		GeneratorURL: "/exporter/api/alerts",
+	}
+}
+
+func (a *alerter) send(ctx context.Context, alerts []amAlert) {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		log.Warn("Exporter alert marshal err: ", err)
+		return
+	}
+	for _, webhook := range a.webhooks {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+		if err != nil {
+			log.Warnf("Exporter alert webhook %s err: %s", webhook, err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := a.client.Do(req)
+		if err != nil {
+			log.Warnf("Exporter alert webhook %s err: %s", webhook, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			log.Warnf("Exporter alert webhook %s unexpected status code %d", webhook, resp.StatusCode)
+		}
+	}
+}
+
+// active 返回当前 pending 和 firing 的告警，按规则名排序
+func (a *alerter) active() []*alert {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	result := []*alert{}
+	for _, rule := range a.rules {
+		keys := make([]string, 0, len(a.alerts[rule.name]))
+		for key := range a.alerts[rule.name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			al := *a.alerts[rule.name][key]
+			result = append(result, &al)
+		}
+	}
+	return result
+}
+
+// Describe 不返回任何描述，ALERTS 的标签随告警的序列变化，以 unchecked collector 的方式注册
+func (a *alerter) Describe(ch chan<- *prometheus.Desc) {
+}
+
+// Collect 输出与 Prometheus 相同含义的 ALERTS 指标，值恒为 1
+func (a *alerter) Collect(ch chan<- prometheus.Metric) {
+	for _, al := range a.active() {
+		labels := prometheus.Labels{}
+		for k, v := range al.Labels {
+			labels[k] = v
+		}
+		labels["alertstate"] = al.State
+		for k, v := range collector.GlobalLabel {
+			if _, ok := labels[k]; !ok {
+				labels[k] = v
+			}
+		}
+		desc := prometheus.NewDesc("ALERTS", "当前 pending 和 firing 的告警", nil, labels)
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1)
+	}
+}
+
+// API_alerts 返回当前 pending 和 firing 的告警
+func (p *ExporterConfig) API_alerts(w http.ResponseWriter, r *http.Request) {
+	if !p.accept(w, r) {
+		return
+	}
+	alerts := []*alert{}
+	if p.alerter != nil {
+		alerts = p.alerter.active()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"alerts": alerts})
+}
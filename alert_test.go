@@ -0,0 +1,141 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"m7s.live/engine/v4/config"
+)
+
+func TestParseAlertRule(t *testing.T) {
+	tests := []struct {
+		expr      string
+		name      string
+		op        string
+		threshold float64
+		duration  time.Duration
+		wantErr   bool
+	}{
+		{expr: "load > 90", name: "load", op: ">", threshold: 90},
+		{expr: `monibuca_disk_used_percent{path="/"} >= 90.5 for 5m`, name: "monibuca_disk_used_percent", op: ">=", threshold: 90.5, duration: 5 * time.Minute},
+		{expr: "publishers<1 for 30s", name: "publishers", op: "<", threshold: 1, duration: 30 * time.Second},
+		{expr: "up == 0", name: "up", op: "==", threshold: 0},
+		{expr: "up != 1", name: "up", op: "!=", threshold: 1},
+		{expr: "up <= -1e3", name: "up", op: "<=", threshold: -1000},
+		{expr: "up", wantErr: true},
+		{expr: "up > abc", wantErr: true},
+		{expr: "up > 1 for 5", wantErr: true},
+		{expr: `{job="a"} > 1`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			rule, err := parseAlertRule("test", tt.expr)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseAlertRule(%q) = %+v, want error", tt.expr, rule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAlertRule(%q) err: %v", tt.expr, err)
+			}
+			if rule.selector.name != tt.name || rule.op != tt.op || rule.threshold != tt.threshold || rule.duration != tt.duration {
+				t.Errorf("got %s %s %v for %v, want %s %s %v for %v",
+					rule.selector.name, rule.op, rule.threshold, rule.duration, tt.name, tt.op, tt.threshold, tt.duration)
+			}
+		})
+	}
+}
+
+func TestAlerterStateMachine(t *testing.T) {
+	const interval = 15 * time.Second
+	a, err := newAlerter(AlertConfig{
+		Interval: interval,
+		Rules:    config.Config{"HighLoad": "load > 90 for 1m"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1000, 0)
+	load := func(v float64) []sample {
+		return []sample{
+			{Name: "load", Labels: []labelPair{{"host", "a"}}, Value: v},
+			{Name: "other", Labels: []labelPair{{"host", "a"}}, Value: 100},
+		}
+	}
+
+	steps := []struct {
+		name       string
+		at         time.Duration //距离 start 的时间
+		samples    []sample
+		state      string //评估后告警的状态，为空表示没有告警
+		activeAt   time.Duration
+		notify     bool
+		notifyEnds time.Duration //通知中的 endsAt
+	}{
+		{name: "below threshold", at: 0, samples: load(50)},
+		{name: "pending", at: 15 * time.Second, samples: load(95), state: alertPending, activeAt: 15 * time.Second},
+		{name: "still pending", at: 30 * time.Second, samples: load(96), state: alertPending, activeAt: 15 * time.Second},
+		{name: "firing", at: 75 * time.Second, samples: load(97), state: alertFiring, activeAt: 15 * time.Second,
+			notify: true, notifyEnds: 75*time.Second + 3*interval},
+		{name: "firing resent", at: 90 * time.Second, samples: load(98), state: alertFiring, activeAt: 15 * time.Second,
+			notify: true, notifyEnds: 90*time.Second + 3*interval},
+		{name: "resolved", at: 105 * time.Second, samples: load(10),
+			notify: true, notifyEnds: 105 * time.Second},
+		{name: "resolved only once", at: 120 * time.Second, samples: load(10)},
+		{name: "pending again", at: 135 * time.Second, samples: load(95), state: alertPending, activeAt: 135 * time.Second},
+		{name: "pending cleared without notify", at: 150 * time.Second, samples: nil},
+	}
+	for _, step := range steps {
+		now := start.Add(step.at)
+		notify := a.eval(step.samples, now)
+		active := a.active()
+		if step.state == "" {
+			if len(active) != 0 {
+				t.Errorf("%s: got %d active alerts, want none", step.name, len(active))
+			}
+		} else if len(active) != 1 {
+			t.Errorf("%s: got %d active alerts, want 1", step.name, len(active))
+		} else {
+			al := active[0]
+			if al.State != step.state || !al.ActiveAt.Equal(start.Add(step.activeAt)) {
+				t.Errorf("%s: got state %s active at %v, want %s at %v", step.name, al.State, al.ActiveAt, step.state, start.Add(step.activeAt))
+			}
+			if al.Labels["alertname"] != "HighLoad" || al.Labels["host"] != "a" {
+				t.Errorf("%s: unexpected labels %v", step.name, al.Labels)
+			}
+		}
+		if !step.notify {
+			if len(notify) != 0 {
+				t.Errorf("%s: got %d notifications, want none", step.name, len(notify))
+			}
+			continue
+		}
+		if len(notify) != 1 {
+			t.Errorf("%s: got %d notifications, want 1", step.name, len(notify))
+			continue
+		}
+		if !notify[0].EndsAt.Equal(start.Add(step.notifyEnds)) || !notify[0].StartsAt.Equal(start.Add(15*time.Second)) {
+			t.Errorf("%s: got startsAt %v endsAt %v, want endsAt %v", step.name, notify[0].StartsAt, notify[0].EndsAt, start.Add(step.notifyEnds))
+		}
+	}
+}
+
+func TestNewAlerterInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		conf AlertConfig
+	}{
+		{"zero interval", AlertConfig{Rules: config.Config{"Up": "up < 1"}}},
+		{"negative interval", AlertConfig{Interval: -time.Second, Rules: config.Config{"Up": "up < 1"}}},
+		{"rule not a string", AlertConfig{Interval: time.Second, Rules: config.Config{"Up": 1}}},
+		{"invalid rule", AlertConfig{Interval: time.Second, Rules: config.Config{"Up": "up"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newAlerter(tt.conf, nil); err == nil {
+				t.Error("newAlerter succeeded, want error")
+			}
+		})
+	}
+}
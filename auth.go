@@ -0,0 +1,122 @@
+package exporter
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"m7s.live/engine/v4/log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type AuthConfig struct {
+	Username  string //basic auth 用户名
+	Password  string //basic auth 密码的 bcrypt 哈希，可用 htpasswd -nbB 生成
+	Token     string //bearer token
+	AllowCIDR string //允许访问的网段，多个用逗号分隔，为空不限制
+	networks  []*net.IPNet
+	err       error //配置错误，不为 nil 时拒绝所有请求，配置写错不能变成不限制访问
+}
+
+// init 解析 allowcidr 并检查 password 是否为 bcrypt 哈希，任何一项无效时返回错误，之后所有请求都会被拒绝
+func (a *AuthConfig) init() error {
+	a.networks, a.err = nil, nil
+	if a.Username != "" {
+		if _, err := bcrypt.Cost([]byte(a.Password)); err != nil {
+			a.err = fmt.Errorf("password must be a bcrypt hash: %w", err)
+			return a.err
+		}
+	}
+	for _, cidr := range strings.Split(a.AllowCIDR, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			a.networks = nil
+			a.err = fmt.Errorf("invalid allowcidr %q: %w", cidr, err)
+			return a.err
+		}
+		a.networks = append(a.networks, network)
+	}
+	return nil
+}
+
+func (a *AuthConfig) allowed(remoteAddr string) bool {
+	if a.err != nil {
+		return false
+	}
+	if len(a.networks) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range a.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *AuthConfig) authenticated(r *http.Request) bool {
+	if a.Username == "" && a.Token == "" {
+		return true
+	}
+	if a.Token != "" {
+		header := r.Header.Get("Authorization")
+		if strings.HasPrefix(header, "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(a.Token)) == 1 {
+			return true
+		}
+	}
+	if a.Username != "" {
+		if username, password, ok := r.BasicAuth(); ok &&
+			subtle.ConstantTimeCompare([]byte(username), []byte(a.Username)) == 1 &&
+			bcrypt.CompareHashAndPassword([]byte(a.Password), []byte(password)) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize 校验访问来源和身份，不通过时写入 403 或 401 响应并返回 false
+func (p *ExporterConfig) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if !p.Auth.allowed(r.RemoteAddr) {
+		p.rejected.WithLabelValues("forbidden").Inc()
+		//每个请求都可能触发，只打 debug 日志，配置错误已经在初始化时打过 error 日志，拒绝次数看 rejected_requests_total
+		if p.Auth.err != nil {
+			log.Debugf("Exporter reject request from %s: %s", r.RemoteAddr, p.Auth.err)
+		} else {
+			log.Debugf("Exporter reject request from %s: not in allowcidr", r.RemoteAddr)
+		}
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("forbidden"))
+		return false
+	}
+	if !p.Auth.authenticated(r) {
+		p.rejected.WithLabelValues("unauthorized").Inc()
+		if p.Auth.Username != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="monibuca exporter"`)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("unauthorized"))
+		return false
+	}
+	return true
+}
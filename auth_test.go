@@ -0,0 +1,86 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthorize(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic := func(user, pass string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, pass) }
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	tests := []struct {
+		name        string
+		auth        AuthConfig
+		remoteAddr  string
+		setup       func(*http.Request)
+		wantInitErr bool
+		code        int
+		reason      string //被拒绝时 rejected_requests_total 增加的 reason
+	}{
+		{name: "no auth", code: http.StatusOK},
+		{name: "bearer ok", auth: AuthConfig{Token: "t0ken"}, setup: bearer("t0ken"), code: http.StatusOK},
+		{name: "bearer wrong", auth: AuthConfig{Token: "t0ken"}, setup: bearer("other"), code: http.StatusUnauthorized, reason: "unauthorized"},
+		{name: "bearer missing", auth: AuthConfig{Token: "t0ken"}, code: http.StatusUnauthorized, reason: "unauthorized"},
+		{name: "basic bcrypt ok", auth: AuthConfig{Username: "admin", Password: string(hash)}, setup: basic("admin", "secret"), code: http.StatusOK},
+		{name: "basic wrong password", auth: AuthConfig{Username: "admin", Password: string(hash)}, setup: basic("admin", "wrong"), code: http.StatusUnauthorized, reason: "unauthorized"},
+		{name: "basic wrong user", auth: AuthConfig{Username: "admin", Password: string(hash)}, setup: basic("root", "secret"), code: http.StatusUnauthorized, reason: "unauthorized"},
+		{name: "basic sends hash", auth: AuthConfig{Username: "admin", Password: string(hash)}, setup: basic("admin", string(hash)), code: http.StatusUnauthorized, reason: "unauthorized"},
+		{name: "basic or bearer", auth: AuthConfig{Username: "admin", Password: string(hash), Token: "t0ken"}, setup: bearer("t0ken"), code: http.StatusOK},
+		//password 配成明文时初始化报错，之后拒绝所有请求，包括用明文密码访问的
+		{name: "plain password fails closed", auth: AuthConfig{Username: "admin", Password: "secret"}, setup: basic("admin", "secret"),
+			wantInitErr: true, code: http.StatusForbidden, reason: "forbidden"},
+		{name: "cidr allow", auth: AuthConfig{AllowCIDR: "10.0.0.0/8, 192.168.1.1"}, remoteAddr: "10.1.2.3:1234", code: http.StatusOK},
+		{name: "cidr single ip", auth: AuthConfig{AllowCIDR: "10.0.0.0/8, 192.168.1.1"}, remoteAddr: "192.168.1.1:1234", code: http.StatusOK},
+		{name: "cidr ipv6", auth: AuthConfig{AllowCIDR: "::1"}, remoteAddr: "[::1]:1234", code: http.StatusOK},
+		{name: "cidr deny", auth: AuthConfig{AllowCIDR: "10.0.0.0/8, 192.168.1.1"}, remoteAddr: "192.168.1.2:1234", code: http.StatusForbidden, reason: "forbidden"},
+		{name: "cidr checked before auth", auth: AuthConfig{AllowCIDR: "10.0.0.0/8", Token: "t0ken"}, remoteAddr: "192.168.1.2:1234", setup: bearer("t0ken"), code: http.StatusForbidden, reason: "forbidden"},
+		{name: "cidr allow then unauthorized", auth: AuthConfig{AllowCIDR: "10.0.0.0/8", Token: "t0ken"}, remoteAddr: "10.1.2.3:1234", code: http.StatusUnauthorized, reason: "unauthorized"},
+		{name: "bad cidr fails closed", auth: AuthConfig{AllowCIDR: "10.0.0.0/8, 300.0.0.0/8"}, remoteAddr: "10.1.2.3:1234", wantInitErr: true, code: http.StatusForbidden, reason: "forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestExporter("")
+			p.Auth = tt.auth
+			if err := p.Auth.init(); (err != nil) != tt.wantInitErr {
+				t.Fatalf("init() err = %v, want error %v", err, tt.wantInitErr)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/exporter/api/metrics", nil)
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+			if tt.setup != nil {
+				tt.setup(r)
+			}
+			w := httptest.NewRecorder()
+			ok := p.authorize(w, r)
+			if ok != (tt.code == http.StatusOK) || w.Code != tt.code {
+				t.Errorf("authorize = %v with status %d, want %d", ok, w.Code, tt.code)
+			}
+			if tt.code == http.StatusUnauthorized && tt.auth.Username != "" && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate header")
+			}
+			for _, reason := range []string{"forbidden", "unauthorized"} {
+				want := 0.0
+				if reason == tt.reason {
+					want = 1
+				}
+				if got := testutil.ToFloat64(p.rejected.WithLabelValues(reason)); got != want {
+					t.Errorf("rejected_requests_total{reason=%q} = %v, want %v", reason, got, want)
+				}
+			}
+		})
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"github.com/shirou/gopsutil/v3/host"
 	"github.com/shirou/gopsutil/v3/process"
 	"m7s.live/engine/v4"
-	"m7s.live/engine/v4/config"
 	"m7s.live/engine/v4/log"
 	"math"
 	"os"
@@ -15,7 +14,7 @@ import (
 )
 
 func init() {
-	RegisterCollector("base", newBaseCollector)
+	RegisterTypedCollector("base", NoConfig{}, newBaseCollector)
 }
 
 func version2float(version string) float64 {
@@ -34,11 +33,14 @@ func version2float(version string) float64 {
 }
 
 type baseCollectorBasic struct {
+	Info              *prometheus.Desc
+	OS                *prometheus.Desc
 	RunTime           *prometheus.Desc
 	ProcessMemory     *prometheus.Desc
 	ProcessCpuTime    *prometheus.Desc
 	ProcessCpuPercent *prometheus.Desc
 	pid               int
+	version           float64
 }
 
 func (c *baseCollectorBasic) OnEvent(event any) {
@@ -46,6 +48,8 @@ func (c *baseCollectorBasic) OnEvent(event any) {
 }
 
 func (c *baseCollectorBasic) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.Info
+	ch <- c.OS
 	ch <- c.RunTime
 	ch <- c.ProcessCpuTime
 	ch <- c.ProcessCpuPercent
@@ -54,6 +58,9 @@ func (c *baseCollectorBasic) Describe(ch chan<- *prometheus.Desc) {
 func (c *baseCollectorBasic) Collect(ch chan<- prometheus.Metric) {
 	start := engine.SysInfo.StartTime
 
+	ch <- prometheus.MustNewConstMetric(c.Info, prometheus.GaugeValue, c.version)
+	ch <- prometheus.MustNewConstMetric(c.OS, prometheus.GaugeValue, c.version)
+
 	ch <- prometheus.MustNewConstMetric(
 		c.RunTime, prometheus.CounterValue, time.Now().Sub(start).Seconds(), start.Format("2006-01-02 15:04:05"),
 	)
@@ -85,50 +92,41 @@ func (c *baseCollectorBasic) Collect(ch chan<- prometheus.Metric) {
 
 }
 
-func onetime_baseInfo(subsystem string) {
-	label := make(prometheus.Labels)
+// baseInfoLabels 返回 info 和 os 指标的常量标签，基础信息和系统信息在进程运行期间不变，
+// 作为采集器自己的指标输出，采集器重建或停用时不会在默认的 registry 中残留或重复注册
+func baseInfoLabels() (info, system prometheus.Labels) {
+	info, system = make(prometheus.Labels), make(prometheus.Labels)
 	for k, v := range GlobalLabel {
-		label[k] = v
+		info[k] = v
+		system[k] = v
 	}
-	label["ip"] = engine.SysInfo.LocalIP
-	label["version"] = engine.SysInfo.Version
-
-	m7sVer := version2float(engine.SysInfo.Version)
-
-	baseGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace:   Namespace,
-		Subsystem:   subsystem,
-		Name:        "info",
-		Help:        "Monibuca 基础信息",
-		ConstLabels: label,
-	})
-	prometheus.MustRegister(baseGauge)
-	baseGauge.Set(m7sVer)
+	info["ip"] = engine.SysInfo.LocalIP
+	info["version"] = engine.SysInfo.Version
 
-	delete(label, "ip")
-	delete(label, "version")
 	platform, family, kernelVersion, _ := host.PlatformInformation()
-
-	label["platform"] = platform
-	label["family"] = family
-	label["kernel_version"] = kernelVersion
-	label["pid"] = strconv.Itoa(os.Getpid())
-	systemGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace:   Namespace,
-		Subsystem:   subsystem,
-		Name:        "os",
-		Help:        "系统基础信息",
-		ConstLabels: label,
-	})
-
-	prometheus.MustRegister(systemGauge)
-	systemGauge.Set(m7sVer)
+	system["platform"] = platform
+	system["family"] = family
+	system["kernel_version"] = kernelVersion
+	system["pid"] = strconv.Itoa(os.Getpid())
+	return
 }
 
-func newBaseCollector(cfg config.Config) (Collector, error) {
+func newBaseCollector(*NoConfig) (Collector, error) {
 	const subsystem = "base"
-	onetime_baseInfo(subsystem)
+	infoLabels, osLabels := baseInfoLabels()
 	return &baseCollectorBasic{
+		Info: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "info"),
+			"Monibuca 基础信息",
+			nil,
+			infoLabels,
+		),
+		OS: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "os"),
+			"系统基础信息",
+			nil,
+			osLabels,
+		),
 		RunTime: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "running_time"),
 			"Monibuca 运行时间",
@@ -153,6 +151,7 @@ func newBaseCollector(cfg config.Config) (Collector, error) {
 			[]string{"time_type"},
 			GlobalLabel,
 		),
-		pid: os.Getpid(),
+		pid:     os.Getpid(),
+		version: version2float(engine.SysInfo.Version),
 	}, nil
 }
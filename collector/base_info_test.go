@@ -0,0 +1,31 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestBaseBuildTwice 热加载和重新启用都会再次构建 base，info 和 os 只能由采集器自己输出，不能重复注册
+func TestBaseBuildTwice(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		c, err := Build("base", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reg := prometheus.NewPedanticRegistry()
+		reg.MustRegister(c)
+		for _, name := range []string{"monibuca_base_info", "monibuca_base_os"} {
+			gatherValue(t, reg, name, nil)
+		}
+	}
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "monibuca_base_info" || mf.GetName() == "monibuca_base_os" {
+			t.Errorf("%s is registered in the default registry", mf.GetName())
+		}
+	}
+}
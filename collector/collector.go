@@ -1,9 +1,14 @@
 package collector
 
 import (
+	"context"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"m7s.live/engine/v4/config"
+	"reflect"
+	"sync"
+	"time"
 )
 
 const (
@@ -12,14 +17,53 @@ const (
 
 var GlobalLabel prometheus.Labels
 
+// DefaultTimeout 采集器配置中没有 timeout 时使用的超时，为 0 时不限制。
+// 只在热加载中设置并由随后的 Build 读取，两者都在插件的锁内，其它地方需要时应从插件配置传入
+var DefaultTimeout time.Duration
+
+// options 每个采集器配置中都可以使用的通用配置，由框架处理
+type options struct {
+	Timeout  time.Duration `desc:"采集超时，超时后返回已采集的部分结果，默认使用 exporter.timeout"`
+	Interval time.Duration `desc:"最小采集间隔，间隔内的抓取直接返回上一次成功采集的结果，为 0 时每次抓取都采集"`
+}
+
+// CollectorBuilder 采集器的构建函数，cfg 为 exporter.collector 下对应采集器的原始配置，没有配置时为 nil
 type CollectorBuilder func(cfg config.Config) (Collector, error)
 
+type registration struct {
+	build  CollectorBuilder
+	schema []Option
+}
+
 var (
-	builders = make(map[string]CollectorBuilder)
+	builders = make(map[string]registration)
 )
 
+// RegisterCollector 注册采集器，构建函数自行解析原始配置，配置说明中只有 timeout 和 interval
 func RegisterCollector(name string, builder CollectorBuilder) {
-	builders[name] = builder
+	builders[name] = registration{build: builder}
+}
+
+// RegisterTypedCollector 注册配置为结构体的采集器，defaults 为采集器配置的默认值，必须是结构体。
+// 每次构建时把 exporter.collector 下的配置解析到 defaults 的副本中，多个实例之间不共享配置，
+// 配置实现了 Validator 时会先校验，校验失败的错误由 Build 返回
+func RegisterTypedCollector[T any](name string, defaults T, builder func(cfg *T) (Collector, error)) {
+	builders[name] = registration{
+		schema: schemaOf(defaults),
+		build: func(cfg config.Config) (Collector, error) {
+			conf := defaults
+			if cfg != nil {
+				warnUnknownKeys(name, cfg, reflect.TypeOf(defaults))
+				cfg.Unmarshal(&conf)
+			}
+			if v, ok := any(&conf).(Validator); ok {
+				if err := v.Validate(); err != nil {
+					return nil, fmt.Errorf("invalid config: %w", err)
+				}
+			}
+			return builder(&conf)
+		},
+	}
 }
 
 func Available() []string {
@@ -29,15 +73,277 @@ func Available() []string {
 	}
 	return cs
 }
-func Build(collector string, cfg config.Config) (Collector, error) {
-	builder, exists := builders[collector]
+func Build(collector string, cfg config.Config) (c Collector, err error) {
+	r, exists := builders[collector]
 	if !exists {
 		return nil, fmt.Errorf("Unknown CollectorConfig %q", collector)
 	}
-	return builder(cfg)
+	//构建函数 panic 时作为构建失败处理，热加载发生在事件协程和接口中，不能因为一个采集器退出进程
+	defer func() {
+		if e := recover(); e != nil {
+			c, err = nil, fmt.Errorf("build panic: %v", e)
+		}
+	}()
+	if c, err = r.build(cfg); err != nil {
+		return nil, err
+	}
+	opts := options{Timeout: DefaultTimeout}
+	if cfg != nil {
+		cfg.Unmarshal(&opts)
+	}
+	constLabels := prometheus.Labels{"collector": collector}
+	for k, v := range GlobalLabel {
+		constLabels[k] = v
+	}
+	return &wrappedCollector{
+		Collector: c,
+		name:      collector,
+		created:   time.Now(),
+		timeout:   opts.Timeout,
+		interval:  opts.Interval,
+		durationDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "exporter", "collector_duration_seconds"),
+			"采集器最近一次采集的耗时",
+			nil, constLabels),
+		successDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "exporter", "collector_success"),
+			"采集器最近一次采集是否成功，超时或输出无效指标时为 0",
+			nil, constLabels),
+		cacheAgeDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "exporter", "collector_cache_age_seconds"),
+			"返回的采集结果距离实际采集的时间，返回缓存的结果时大于 0",
+			nil, constLabels),
+	}, nil
 }
 
 type Collector interface {
 	prometheus.Collector
 	OnEvent(event any)
 }
+
+// CounterOwner 自己从构建时开始累加计数器的采集器可以实现该接口，返回这些计数器的名称，
+// OpenMetrics 中以采集器的构建时间作为它们的 _created。
+// 来自操作系统等外部的累计值(比如网卡、磁盘的字节数)不知道从什么时候开始计数，不应返回
+type CounterOwner interface {
+	OwnedCounters() []string
+}
+
+// Runner 需要后台采样的采集器可以实现该接口，插件初始化后会在独立的协程中调用 Run，
+// 引擎关闭时 ctx 会被取消
+type Runner interface {
+	Run(ctx context.Context)
+}
+
+// Unwrap 返回 Build 和 Serialized 包装之前的采集器
+func Unwrap(c Collector) Collector {
+	for {
+		switch w := c.(type) {
+		case *wrappedCollector:
+			c = w.Collector
+		case *serializedCollector:
+			c = w.Collector
+		default:
+			return c
+		}
+	}
+}
+
+// Start 如果采集器实现了 Runner，则在独立的协程中启动它
+func Start(ctx context.Context, c Collector) {
+	if r, ok := Unwrap(c).(Runner); ok {
+		go r.Run(ctx)
+	}
+}
+
+// Stats 采集器最近一次采集的情况
+type Stats struct {
+	Time     time.Time     //最近一次采集的开始时间，未采集过时为零值
+	Duration time.Duration //最近一次采集的耗时
+	Err      error         //最近一次采集超时、仍未结束或输出的第一个无效指标的错误
+}
+
+// StatsOf 返回由 Build 构建的采集器最近一次采集的情况
+func StatsOf(c Collector) (Stats, bool) {
+	l, ok := c.(*wrappedCollector)
+	if !ok {
+		return Stats{}, false
+	}
+	l.statsMu.RLock()
+	defer l.statsMu.RUnlock()
+	return l.stats, true
+}
+
+// CreatedOf 返回由 Build 构建的采集器自己累加的计数器名称到起点(构建时间)的映射
+func CreatedOf(c Collector) map[string]time.Time {
+	l, ok := c.(*wrappedCollector)
+	if !ok {
+		return nil
+	}
+	owner, ok := Unwrap(c).(CounterOwner)
+	if !ok {
+		return nil
+	}
+	result := make(map[string]time.Time)
+	for _, name := range owner.OwnedCounters() {
+		result[name] = l.created
+	}
+	return result
+}
+
+// wrappedCollector 包装 Build 构建的采集器，记录每次采集的耗时和错误，
+// 输出 collector_duration_seconds 和 collector_success 指标，
+// 采集超时后返回已经采集的部分结果，配置了 interval 时在间隔内返回缓存的结果。
+// 同一时间只有一次采集在进行，并发的抓取共享它的结果，采集卡住时之后的抓取不再等待，
+// 直接返回上一次成功采集的结果，不会不断堆积协程。
+// 包装器不串行化 OnEvent 和 Collect，需要时由采集器用 Serialized 包装
+type wrappedCollector struct {
+	Collector
+	name         string
+	created      time.Time //构建时间
+	timeout      time.Duration
+	interval     time.Duration
+	durationDesc *prometheus.Desc
+	successDesc  *prometheus.Desc
+	cacheAgeDesc *prometheus.Desc
+	statsMu      sync.RWMutex
+	stats        Stats
+
+	mu        sync.Mutex //保护以下字段
+	running   *flight    //正在进行的采集
+	cache     []prometheus.Metric
+	cacheTime time.Time //上一次成功采集的开始时间
+}
+
+// flight 一次正在进行的采集，done 关闭后 metrics 和 err 不再变化
+type flight struct {
+	start   time.Time
+	done    chan struct{}
+	mu      sync.Mutex
+	metrics []prometheus.Metric
+	err     error
+}
+
+// partial 返回采集到目前为止输出的指标
+func (f *flight) partial() []prometheus.Metric {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]prometheus.Metric(nil), f.metrics...)
+}
+
+// Describe 采集器没有描述任何指标时(unchecked collector)也不描述自身的指标，避免它输出的指标被注册表拒绝
+func (c *wrappedCollector) Describe(ch chan<- *prometheus.Desc) {
+	descs := make(chan *prometheus.Desc)
+	go func() {
+		defer close(descs)
+		c.Collector.Describe(descs)
+	}()
+	described := false
+	for desc := range descs {
+		described = true
+		ch <- desc
+	}
+	if described {
+		ch <- c.durationDesc
+		ch <- c.successDesc
+		ch <- c.cacheAgeDesc
+	}
+}
+
+func (c *wrappedCollector) Collect(ch chan<- prometheus.Metric) {
+	metrics, age := c.result()
+	for _, metric := range metrics {
+		ch <- metric
+	}
+	c.collectStats(ch)
+	ch <- prometheus.MustNewConstMetric(c.cacheAgeDesc, prometheus.GaugeValue, age.Seconds())
+}
+
+// result 返回本次抓取的指标以及它们距离实际采集的时间
+func (c *wrappedCollector) result() ([]prometheus.Metric, time.Duration) {
+	now := time.Now()
+	c.mu.Lock()
+	if c.interval > 0 && !c.cacheTime.IsZero() && now.Sub(c.cacheTime) < c.interval {
+		defer c.mu.Unlock()
+		return c.cache, now.Sub(c.cacheTime)
+	}
+	f := c.running
+	if f == nil {
+		f = c.start(now)
+	} else if c.timeout > 0 && now.Sub(f.start) >= c.timeout {
+		// 上一次采集超时后仍未结束，不再排队等待，返回上一次成功采集的结果
+		cache, cacheTime := c.cache, c.cacheTime
+		c.mu.Unlock()
+		c.setStats(Stats{Time: f.start, Duration: now.Sub(f.start), Err: fmt.Errorf("collector %s still running after %s", c.name, c.timeout)})
+		if cacheTime.IsZero() {
+			return nil, 0
+		}
+		return cache, now.Sub(cacheTime)
+	}
+	c.mu.Unlock()
+
+	if c.timeout <= 0 {
+		<-f.done
+		return f.metrics, 0
+	}
+	timer := time.NewTimer(f.start.Add(c.timeout).Sub(now))
+	defer timer.Stop()
+	select {
+	case <-f.done:
+		return f.metrics, 0
+	case <-timer.C:
+		c.setStats(Stats{Time: f.start, Duration: time.Since(f.start), Err: fmt.Errorf("collector %s timeout after %s", c.name, c.timeout)})
+		return f.partial(), 0
+	}
+}
+
+// start 开始一次采集，调用时需要持有 c.mu。采集在独立的协程中进行，结束后记录耗时和错误，
+// 成功时更新缓存
+func (c *wrappedCollector) start(now time.Time) *flight {
+	f := &flight{start: now, done: make(chan struct{})}
+	c.running = f
+	go func() {
+		metrics := make(chan prometheus.Metric)
+		go func() {
+			defer close(metrics)
+			c.Collector.Collect(metrics)
+		}()
+		var m dto.Metric
+		for metric := range metrics {
+			f.mu.Lock()
+			if f.err == nil {
+				m.Reset()
+				f.err = metric.Write(&m)
+			}
+			f.metrics = append(f.metrics, metric)
+			f.mu.Unlock()
+		}
+		c.mu.Lock()
+		c.running = nil
+		if f.err == nil {
+			c.cache, c.cacheTime = f.metrics, f.start
+		}
+		c.mu.Unlock()
+		c.setStats(Stats{Time: f.start, Duration: time.Since(f.start), Err: f.err})
+		close(f.done)
+	}()
+	return f
+}
+
+func (c *wrappedCollector) setStats(stats Stats) {
+	c.statsMu.Lock()
+	c.stats = stats
+	c.statsMu.Unlock()
+}
+
+// collectStats 输出最近一次采集的耗时和是否成功
+func (c *wrappedCollector) collectStats(ch chan<- prometheus.Metric) {
+	c.statsMu.RLock()
+	stats := c.stats
+	c.statsMu.RUnlock()
+	success := 1.0
+	if stats.Err != nil {
+		success = 0
+	}
+	ch <- prometheus.MustNewConstMetric(c.durationDesc, prometheus.GaugeValue, stats.Duration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.successDesc, prometheus.GaugeValue, success)
+}
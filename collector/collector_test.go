@@ -0,0 +1,153 @@
+package collector
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"m7s.live/engine/v4/config"
+)
+
+// blockingCollector 每次采集先输出一个指标，然后等待 release 才输出第二个，用来模拟卡住的采集
+type blockingCollector struct {
+	desc    *prometheus.Desc
+	release chan struct{}
+	events  chan any
+}
+
+func (c *blockingCollector) Describe(ch chan<- *prometheus.Desc) { ch <- c.desc }
+
+func (c *blockingCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, "first")
+	<-c.release
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 2, "second")
+}
+
+func (c *blockingCollector) OnEvent(event any) { c.events <- event }
+
+func TestCollectTimeout(t *testing.T) {
+	blocking := &blockingCollector{
+		desc:    prometheus.NewDesc("test_blocking", "test", []string{"step"}, nil),
+		release: make(chan struct{}),
+		events:  make(chan any, 1),
+	}
+	RegisterTypedCollector("test_blocking", NoConfig{}, func(*NoConfig) (Collector, error) { return blocking, nil })
+	t.Cleanup(func() { delete(builders, "test_blocking") })
+	DefaultTimeout = 50 * time.Millisecond
+	defer func() { DefaultTimeout = 0 }()
+	c, err := Build("test_blocking", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+
+	// 第一次抓取超时，返回部分结果
+	if got := gatherValue(t, reg, "test_blocking", map[string]string{"step": "first"}); got != 1 {
+		t.Errorf("partial result = %v, want 1", got)
+	}
+	if got := gatherValue(t, reg, "monibuca_exporter_collector_success", nil); got != 0 {
+		t.Errorf("success after timeout = %v, want 0", got)
+	}
+
+	// 采集卡住时之后的抓取立即返回，不会堆积协程
+	goroutines := runtime.NumGoroutine()
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		if _, err := reg.Gather(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("gather while stuck took %s", elapsed)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines+2 {
+		t.Errorf("goroutines grew from %d to %d", goroutines, n)
+	}
+
+	// 采集卡住时事件照常送达
+	done := make(chan struct{})
+	go func() {
+		c.OnEvent("event")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("OnEvent blocked by a stuck collect")
+	}
+
+	// 采集结束后恢复，成功的结果会缓存下来
+	close(blocking.release)
+	w := c.(*wrappedCollector)
+	w.mu.Lock()
+	f := w.running
+	w.mu.Unlock()
+	if f != nil {
+		select {
+		case <-f.done:
+		case <-time.After(time.Second):
+			t.Fatal("collect did not finish after release")
+		}
+	}
+	if got := gatherValue(t, reg, "test_blocking", map[string]string{"step": "second"}); got != 2 {
+		t.Errorf("result after release = %v, want 2", got)
+	}
+	if got := gatherValue(t, reg, "monibuca_exporter_collector_success", nil); got != 1 {
+		t.Errorf("success after release = %v, want 1", got)
+	}
+}
+
+func TestRegisterCollector(t *testing.T) {
+	var got config.Config
+	t.Cleanup(func() { delete(builders, "test_untyped") })
+	RegisterCollector("test_untyped", func(cfg config.Config) (Collector, error) {
+		got = cfg
+		if cfg.Has("fail") {
+			return nil, errors.New("fail")
+		}
+		return &blockingCollector{desc: prometheus.NewDesc("test_untyped", "test", nil, nil)}, nil
+	})
+	defer delete(builders, "test_untyped")
+
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{"nil config", nil, false},
+		{"raw config", config.Config{"foo": "bar"}, false},
+		{"builder error", config.Config{"fail": true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Build("test_untyped", tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Build err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.cfg) {
+				t.Errorf("builder got config %v, want %v", got, tt.cfg)
+			}
+			if err == nil && c == nil {
+				t.Error("Build returned nil collector")
+			}
+		})
+	}
+
+	//没有配置结构体，配置说明中只有框架处理的 timeout 和 interval
+	schema, ok := Schema("test_untyped", 3*time.Second)
+	if !ok || len(schema) != 2 || schema[0].Name != "timeout" || schema[0].Default != "3s" || schema[1].Name != "interval" {
+		t.Errorf("schema = %+v", schema)
+	}
+}
+
+func TestBuildPanic(t *testing.T) {
+	RegisterCollector("test_panic", func(config.Config) (Collector, error) { panic("duplicate metrics collector registration attempted") })
+	t.Cleanup(func() { delete(builders, "test_panic") })
+	if c, err := Build("test_panic", nil); err == nil || c != nil {
+		t.Errorf("Build = %v, %v, want error", c, err)
+	}
+}
@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"fmt"
+	"m7s.live/engine/v4/config"
+	"m7s.live/engine/v4/log"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NoConfig 没有配置项的采集器使用的配置类型
+type NoConfig struct{}
+
+// Validator 采集器的配置可以实现该接口，构建前会调用 Validate 校验解析后的配置
+type Validator interface {
+	Validate() error
+}
+
+// Option 采集器的一个配置项，由配置结构体的字段和 desc 标签生成
+type Option struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Default any    `json:"default"`
+	Desc    string `json:"desc"`
+}
+
+// schemaOf 反射配置结构体，生成配置项的说明，名称与 yaml 中一样使用小写
+func schemaOf(defaults any) []Option {
+	v := reflect.ValueOf(defaults)
+	t := v.Type()
+	result := []Option{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		value := v.Field(i).Interface()
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		result = append(result, Option{
+			Name:    strings.ToLower(field.Name),
+			Type:    field.Type.String(),
+			Default: value,
+			Desc:    field.Tag.Get("desc"),
+		})
+	}
+	return result
+}
+
+// Schema 返回采集器的配置项，包括每个采集器都可以使用的 timeout 和 interval，
+// defaultTimeout 为 exporter.timeout，作为 timeout 配置项的默认值
+func Schema(name string, defaultTimeout time.Duration) ([]Option, bool) {
+	r, exists := builders[name]
+	if !exists {
+		return nil, false
+	}
+	return append(append([]Option{}, r.schema...), schemaOf(options{Timeout: defaultTimeout})...), true
+}
+
+// warnUnknownKeys 对配置中采集器不认识的键打印警告，通常是拼写错误
+func warnUnknownKeys(name string, cfg config.Config, t reflect.Type) {
+	known := map[string]bool{}
+	for _, typ := range []reflect.Type{t, reflect.TypeOf(options{})} {
+		for i := 0; i < typ.NumField(); i++ {
+			known[strings.ToLower(typ.Field(i).Name)] = true
+		}
+	}
+	var unknown []string
+	for k := range cfg {
+		if !known[strings.ToLower(k)] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		log.Warnf("Exporter collector %s ignores unknown config keys: %s", name, strings.Join(unknown, ","))
+	}
+}
+
+// compilePattern 编译完整匹配的正则表达式，用于名称白名单、黑名单等配置
+func compilePattern(key, pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", pattern))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", key, pattern, err)
+	}
+	return re, nil
+}
@@ -1,77 +1,160 @@
 package collector
 
 import (
+	"context"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/shirou/gopsutil/v3/cpu"
-	"m7s.live/engine/v4/config"
+	"m7s.live/engine/v4/log"
+	"sync"
 	"time"
 )
 
 func init() {
-	RegisterCollector("cpu", newCPUCollector)
+	RegisterTypedCollector("cpu", cpuConfig{Window: time.Second, History: 60}, newCPUCollector)
 }
 
 type cpuCollectorBasic struct {
 	UserTime   *prometheus.Desc
 	Usage      *prometheus.Desc
+	UsageAvg   *prometheus.Desc
 	SystemTime *prometheus.Desc
 	IdleTime   *prometheus.Desc
+
+	conf    cpuConfig
+	mu      sync.RWMutex
+	times   []cpu.TimesStat      //最近一次采样的 cpu 时间
+	history map[string][]float64 //每个核心最近的利用率，最后一个为最新值
 }
 
-var cpuConfig = struct {
-	PerCpu bool
-}{PerCpu: false}
+type cpuConfig struct {
+	PerCpu  bool          `desc:"是否分别统计每个处理器"`
+	Window  time.Duration `desc:"后台采样窗口，利用率为两次采样之间的变化计算得出"`
+	History int           `desc:"每个处理器保留的历史采样数，用于计算平均利用率"`
+}
+
+func (c *cpuConfig) Validate() error {
+	if c.Window <= 0 {
+		return fmt.Errorf("invalid window %s", c.Window)
+	}
+	if c.History <= 0 {
+		return fmt.Errorf("invalid history %d", c.History)
+	}
+	return nil
+}
 
 func (c *cpuCollectorBasic) OnEvent(event any) {
 
 }
 
+// Run 后台按采样窗口定时采样，Collect 只读取最近的计算结果，不阻塞抓取
+func (c *cpuCollectorBasic) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.conf.Window)
+	defer ticker.Stop()
+	c.sample()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.sample()
+		}
+	}
+}
+
+func (c *cpuCollectorBasic) sample() {
+	cpuStats, err := cpu.Times(c.conf.PerCpu)
+	if err != nil {
+		log.Warn("Exporter cpu sample err: ", err)
+		return
+	}
+	c.update(cpuStats)
+}
+
+// update 根据与上一次采样之间的变化计算每个处理器的利用率，记录到历史中
+func (c *cpuCollectorBasic) update(cpuStats []cpu.TimesStat) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, cpuStat := range cpuStats {
+		if i >= len(c.times) || c.times[i].CPU != cpuStat.CPU {
+			continue
+		}
+		total, busy := cpuBusy(cpuStat)
+		lastTotal, lastBusy := cpuBusy(c.times[i])
+		usage := 0.0
+		if total > lastTotal {
+			usage = (busy - lastBusy) / (total - lastTotal) * 100
+		}
+		if usage < 0 {
+			usage = 0
+		}
+		label := c.cpuUsageLabel(i)
+		history := append(c.history[label], usage)
+		if len(history) > c.conf.History {
+			history = history[len(history)-c.conf.History:]
+		}
+		c.history[label] = history
+	}
+	c.times = cpuStats
+}
+
+// cpuBusy 返回总时间和忙碌时间，计算方式与 gopsutil 的 cpu.Percent 一致
+func cpuBusy(t cpu.TimesStat) (total, busy float64) {
+	total = t.Total() - t.Guest - t.GuestNice
+	busy = total - t.Idle - t.Iowait
+	return
+}
+
+func (c *cpuCollectorBasic) cpuUsageLabel(i int) string {
+	prefix := "cpu"
+	if c.conf.PerCpu == false {
+		return fmt.Sprintf("%s-%s", prefix, "total")
+	}
+	return fmt.Sprintf("%s-%d", prefix, i)
+}
+
 func (c *cpuCollectorBasic) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.UserTime
 	ch <- c.Usage
+	ch <- c.UsageAvg
 	ch <- c.SystemTime
 	ch <- c.IdleTime
 }
 func (c *cpuCollectorBasic) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
-	if usages, err := cpu.Percent(time.Second, cpuConfig.PerCpu); err == nil {
-
-		prefix := "cpu"
-
-		for i, u := range usages {
-			labelVal := ""
-			if cpuConfig.PerCpu == false {
-				labelVal = fmt.Sprintf("%s-%s", prefix, "total")
-			} else {
-				labelVal = fmt.Sprintf("%s-%d", prefix, i)
-			}
-			ch <- prometheus.MustNewConstMetric(
-				c.Usage, prometheus.GaugeValue, u, labelVal,
-			)
+	for labelVal, history := range c.history {
+		if len(history) == 0 {
+			continue
 		}
+		sum := 0.0
+		for _, u := range history {
+			sum += u
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.Usage, prometheus.GaugeValue, history[len(history)-1], labelVal,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.UsageAvg, prometheus.GaugeValue, sum/float64(len(history)), labelVal,
+		)
 	}
 
-	if cpuStats, err := cpu.Times(cpuConfig.PerCpu); err == nil {
-		for _, cpuStat := range cpuStats {
-			ch <- prometheus.MustNewConstMetric(
-				c.UserTime, prometheus.GaugeValue, cpuStat.User, cpuStat.CPU,
-			)
-			ch <- prometheus.MustNewConstMetric(
-				c.SystemTime, prometheus.GaugeValue, cpuStat.System, cpuStat.CPU,
-			)
-			ch <- prometheus.MustNewConstMetric(
-				c.IdleTime, prometheus.GaugeValue, cpuStat.Idle, cpuStat.CPU,
-			)
-		}
+	for _, cpuStat := range c.times {
+		ch <- prometheus.MustNewConstMetric(
+			c.UserTime, prometheus.GaugeValue, cpuStat.User, cpuStat.CPU,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.SystemTime, prometheus.GaugeValue, cpuStat.System, cpuStat.CPU,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.IdleTime, prometheus.GaugeValue, cpuStat.Idle, cpuStat.CPU,
+		)
 	}
 }
 
-func newCPUCollector(cfg config.Config) (Collector, error) {
+func newCPUCollector(conf *cpuConfig) (Collector, error) {
 	const subsystem = "cpu"
-	if cfg != nil {
-		cfg.Unmarshal(&cpuConfig)
-	}
 	jiffiesDesc := "(单位：jiffiesDesc 1jiffies=0.01秒)"
 	return &cpuCollectorBasic{
 		UserTime: prometheus.NewDesc(
@@ -86,6 +169,12 @@ func newCPUCollector(cfg config.Config) (Collector, error) {
 			[]string{"core"},
 			GlobalLabel,
 		),
+		UsageAvg: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "usage_avg"),
+			"CPU 最近若干次采样的平均利用率",
+			[]string{"core"},
+			GlobalLabel,
+		),
 		SystemTime: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "system_time"),
 			"系统态的CPU时间"+jiffiesDesc,
@@ -98,5 +187,7 @@ func newCPUCollector(cfg config.Config) (Collector, error) {
 			[]string{"core"},
 			GlobalLabel,
 		),
+		conf:    *conf,
+		history: make(map[string][]float64),
 	}, nil
 }
@@ -0,0 +1,93 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shirou/gopsutil/v3/cpu"
+)
+
+func TestCPUConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    cpuConfig
+		wantErr bool
+	}{
+		{"defaults", cpuConfig{Window: time.Second, History: 60}, false},
+		{"zero window", cpuConfig{History: 60}, true},
+		{"zero history", cpuConfig{Window: time.Second}, true},
+		{"negative history", cpuConfig{Window: time.Second, History: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCPUSampler(t *testing.T) {
+	c, err := newCPUCollector(&cpuConfig{PerCpu: true, Window: time.Second, History: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sampler := c.(*cpuCollectorBasic)
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+
+	steps := []struct {
+		name  string
+		times []cpu.TimesStat
+		usage []float64 //每个核心的最新利用率，为 nil 时还没有利用率
+		avg   []float64 //每个核心保留的历史的平均利用率
+	}{
+		{"first sample has no usage", []cpu.TimesStat{
+			{CPU: "cpu0", User: 10, System: 10, Idle: 80},
+			{CPU: "cpu1", Idle: 100},
+		}, nil, nil},
+		{"usage from delta", []cpu.TimesStat{
+			{CPU: "cpu0", User: 30, System: 20, Idle: 150},
+			{CPU: "cpu1", User: 50, Idle: 150},
+		}, []float64{30, 50}, []float64{30, 50}},
+		{"average over history", []cpu.TimesStat{
+			{CPU: "cpu0", User: 30, System: 20, Idle: 250},
+			{CPU: "cpu1", User: 150, Idle: 150},
+		}, []float64{0, 100}, []float64{15, 75}},
+		//历史只保留 2 个采样，第一个利用率移出窗口；cpu1 没有变化时利用率为 0
+		{"history window", []cpu.TimesStat{
+			{CPU: "cpu0", User: 130, System: 20, Idle: 250},
+			{CPU: "cpu1", User: 150, Idle: 150},
+		}, []float64{100, 0}, []float64{50, 50}},
+	}
+	for _, step := range steps {
+		sampler.update(step.times)
+		for i := range step.usage {
+			core := map[string]string{"core": sampler.cpuUsageLabel(i)}
+			if got := gatherValue(t, reg, "monibuca_cpu_usage", core); got != step.usage[i] {
+				t.Errorf("%s: usage%v = %v, want %v", step.name, core, got, step.usage[i])
+			}
+			if got := gatherValue(t, reg, "monibuca_cpu_usage_avg", core); got != step.avg[i] {
+				t.Errorf("%s: usage_avg%v = %v, want %v", step.name, core, got, step.avg[i])
+			}
+		}
+		if step.usage == nil {
+			mfs, err := reg.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, mf := range mfs {
+				if mf.GetName() == "monibuca_cpu_usage" {
+					t.Errorf("%s: usage reported before the second sample", step.name)
+				}
+			}
+		}
+		if got := gatherValue(t, reg, "monibuca_cpu_idle_time", map[string]string{"core": "cpu0"}); got != step.times[0].Idle {
+			t.Errorf("%s: idle_time = %v, want %v", step.name, got, step.times[0].Idle)
+		}
+	}
+	if n := len(sampler.history[sampler.cpuUsageLabel(0)]); n != 2 {
+		t.Errorf("kept %d samples, want 2", n)
+	}
+}
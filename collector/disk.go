@@ -3,11 +3,19 @@ package collector
 import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/shirou/gopsutil/v3/disk"
-	"m7s.live/engine/v4/config"
+	"m7s.live/engine/v4/log"
+	"regexp"
+	"strings"
 )
 
 func init() {
-	RegisterCollector("disk", newDiskCollector)
+	RegisterTypedCollector("disk", diskConfig{Paths: []string{"/"}, Devices: ".*"}, newDiskCollector)
+}
+
+type diskConfig struct {
+	Paths       []string `desc:"需要统计用量的路径，可以是列表，也兼容逗号分隔的字符串"`
+	MountPoints string   `desc:"需要统计用量的挂载点，支持正则表达式，默认不匹配"`
+	Devices     string   `desc:"需要统计 I/O 的设备，支持正则表达式，默认所有"`
 }
 
 type diskCollectorBasic struct {
@@ -15,6 +23,20 @@ type diskCollectorBasic struct {
 	Total       *prometheus.Desc
 	Used        *prometheus.Desc
 	UsedPercent *prometheus.Desc
+
+	InodesTotal *prometheus.Desc
+	InodesFree  *prometheus.Desc
+	InodesUsed  *prometheus.Desc
+
+	ReadBytes  *prometheus.Desc
+	WriteBytes *prometheus.Desc
+	Reads      *prometheus.Desc
+	Writes     *prometheus.Desc
+	IoTime     *prometheus.Desc
+
+	paths              []string
+	mountPointsPattern *regexp.Regexp
+	devicesPattern     *regexp.Regexp
 }
 
 func (c *diskCollectorBasic) OnEvent(event any) {
@@ -26,27 +48,134 @@ func (c *diskCollectorBasic) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.Total
 	ch <- c.Used
 	ch <- c.UsedPercent
+	ch <- c.InodesTotal
+	ch <- c.InodesFree
+	ch <- c.InodesUsed
+	ch <- c.ReadBytes
+	ch <- c.WriteBytes
+	ch <- c.Reads
+	ch <- c.Writes
+	ch <- c.IoTime
+}
+
+// targets 返回需要统计用量的路径，包括配置的路径和匹配的挂载点
+func (c *diskCollectorBasic) targets() []string {
+	if c.mountPointsPattern == nil {
+		return c.merge(nil)
+	}
+	partitions, err := disk.Partitions(false)
+	if err != nil {
+		log.Warn("Exporter disk partitions err: ", err)
+	}
+	return c.merge(partitions)
+}
+
+// merge 在配置的路径后面追加匹配 mountpoints 的挂载点，已经配置过的路径不重复统计
+func (c *diskCollectorBasic) merge(partitions []disk.PartitionStat) []string {
+	paths := append([]string{}, c.paths...)
+	if c.mountPointsPattern == nil {
+		return paths
+	}
+	for _, p := range partitions {
+		if !c.mountPointsPattern.MatchString(p.Mountpoint) {
+			continue
+		}
+		if !containsString(paths, p.Mountpoint) {
+			paths = append(paths, p.Mountpoint)
+		}
+	}
+	return paths
 }
+
 func (c *diskCollectorBasic) Collect(ch chan<- prometheus.Metric) {
-	path := "/"
-	d, _ := disk.Usage(path)
-
-	ch <- prometheus.MustNewConstMetric(
-		c.Free, prometheus.GaugeValue, float64(d.Free>>30), path,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		c.Used, prometheus.GaugeValue, float64(d.Used>>30), path,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		c.Total, prometheus.GaugeValue, float64(d.Total>>30), path,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		c.UsedPercent, prometheus.GaugeValue, d.UsedPercent, path,
-	)
+	for _, path := range c.targets() {
+		d, err := disk.Usage(path)
+		if err != nil {
+			log.Warnf("Exporter disk usage %s err: %s", path, err)
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.Free, prometheus.GaugeValue, float64(d.Free>>30), path,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.Used, prometheus.GaugeValue, float64(d.Used>>30), path,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.Total, prometheus.GaugeValue, float64(d.Total>>30), path,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.UsedPercent, prometheus.GaugeValue, d.UsedPercent, path,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.InodesTotal, prometheus.GaugeValue, float64(d.InodesTotal), path,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.InodesFree, prometheus.GaugeValue, float64(d.InodesFree), path,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.InodesUsed, prometheus.GaugeValue, float64(d.InodesUsed), path,
+		)
+	}
+
+	counters, err := disk.IOCounters()
+	if err != nil {
+		log.Warn("Exporter disk io counters err: ", err)
+		return
+	}
+	for device, io := range counters {
+		if !c.devicesPattern.MatchString(device) {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.ReadBytes, prometheus.CounterValue, float64(io.ReadBytes), device,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.WriteBytes, prometheus.CounterValue, float64(io.WriteBytes), device,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.Reads, prometheus.CounterValue, float64(io.ReadCount), device,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.Writes, prometheus.CounterValue, float64(io.WriteCount), device,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.IoTime, prometheus.CounterValue, float64(io.IoTime)/1000, device,
+		)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
 }
 
-func newDiskCollector(cfg config.Config) (Collector, error) {
+func newDiskCollector(conf *diskConfig) (Collector, error) {
 	const subsystem = "disk"
+	var paths []string
+	for _, item := range conf.Paths {
+		//兼容旧的 paths: "/,/data" 写法
+		for _, path := range strings.Split(item, ",") {
+			if path = strings.TrimSpace(path); path != "" && !containsString(paths, path) {
+				paths = append(paths, path)
+			}
+		}
+	}
+	var mountPointsPattern *regexp.Regexp
+	if conf.MountPoints != "" {
+		var err error
+		if mountPointsPattern, err = compilePattern("mountpoints", conf.MountPoints); err != nil {
+			return nil, err
+		}
+	}
+	devicesPattern, err := compilePattern("devices", conf.Devices)
+	if err != nil {
+		return nil, err
+	}
 
 	return &diskCollectorBasic{
 		Total: prometheus.NewDesc(
@@ -73,5 +202,56 @@ func newDiskCollector(cfg config.Config) (Collector, error) {
 			[]string{"path"},
 			GlobalLabel,
 		),
+		InodesTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "inodes_total"),
+			"分区 inode 总数",
+			[]string{"path"},
+			GlobalLabel,
+		),
+		InodesFree: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "inodes_free"),
+			"分区剩余 inode 数",
+			[]string{"path"},
+			GlobalLabel,
+		),
+		InodesUsed: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "inodes_used"),
+			"分区已用 inode 数",
+			[]string{"path"},
+			GlobalLabel,
+		),
+		ReadBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "read_bytes_total"),
+			"磁盘读取字节总数 byte",
+			[]string{"device"},
+			GlobalLabel,
+		),
+		WriteBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "written_bytes_total"),
+			"磁盘写入字节总数 byte",
+			[]string{"device"},
+			GlobalLabel,
+		),
+		Reads: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "reads_completed_total"),
+			"磁盘读取次数，配合 rate 可得 IOPS",
+			[]string{"device"},
+			GlobalLabel,
+		),
+		Writes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "writes_completed_total"),
+			"磁盘写入次数，配合 rate 可得 IOPS",
+			[]string{"device"},
+			GlobalLabel,
+		),
+		IoTime: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "io_time_seconds_total"),
+			"磁盘忙于 I/O 的时间(单位秒)",
+			[]string{"device"},
+			GlobalLabel,
+		),
+		paths:              paths,
+		mountPointsPattern: mountPointsPattern,
+		devicesPattern:     devicesPattern,
 	}, nil
 }
@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"m7s.live/engine/v4/config"
+)
+
+func TestDiskPathsConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want []string
+	}{
+		{"default", nil, []string{"/"}},
+		{"list", config.Config{"paths": []any{"/", "/data"}}, []string{"/", "/data"}},
+		{"single string", config.Config{"paths": "/data"}, []string{"/data"}},
+		{"comma separated string", config.Config{"paths": " /, /data ,"}, []string{"/", "/data"}},
+		{"list with comma separated item", config.Config{"paths": []any{"/,/data", "/data", "/logs"}}, []string{"/", "/data", "/logs"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Build("disk", tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Unwrap(c).(*diskCollectorBasic).paths; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("paths = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiskTargets(t *testing.T) {
+	partitions := []disk.PartitionStat{
+		{Device: "/dev/sda1", Mountpoint: "/"},
+		{Device: "/dev/sdb1", Mountpoint: "/data"},
+		{Device: "/dev/sdc1", Mountpoint: "/data/hls"},
+		{Device: "tmpfs", Mountpoint: "/run"},
+	}
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want []string
+	}{
+		{"paths only", config.Config{"paths": []any{"/", "/var"}}, []string{"/", "/var"}},
+		{"mountpoints appended", config.Config{"paths": []any{"/var"}, "mountpoints": "/data.*"}, []string{"/var", "/data", "/data/hls"}},
+		{"configured path not repeated", config.Config{"paths": []any{"/data", "/"}, "mountpoints": "/|/data"}, []string{"/data", "/"}},
+		{"full match only", config.Config{"paths": []any{}, "mountpoints": "/d"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Build("disk", tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := Unwrap(c).(*diskCollectorBasic).merge(partitions)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("targets = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,13 +1,18 @@
 package collector
 
 import (
+	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"m7s.live/engine/v4"
-	"m7s.live/engine/v4/config"
+	"m7s.live/engine/v4/common"
+	"m7s.live/engine/v4/track"
+	"net"
+	"sync"
+	"time"
 )
 
 func init() {
-	RegisterCollector("media", newMediaCollector)
+	RegisterTypedCollector("media", NoConfig{}, newMediaCollector)
 }
 
 type mediaCollectorBasic struct {
@@ -19,17 +24,218 @@ type mediaCollectorBasic struct {
 	StreamBps         *prometheus.Desc
 	StreamSubscribers *prometheus.Desc
 
-	mediaTotal  int64
-	clientTotal int64
+	TrackBps         *prometheus.Desc
+	TrackFps         *prometheus.Desc
+	TrackDrops       *prometheus.Desc
+	TrackCodec       *prometheus.Desc
+	VideoWidth       *prometheus.Desc
+	VideoHeight      *prometheus.Desc
+	VideoGop         *prometheus.Desc
+	KeyframeInterval *prometheus.Desc
+
+	OnlinePublishers  *prometheus.Desc
+	TotalPublishers   *prometheus.Desc
+	OnlineSubscribers *prometheus.Desc
+	TotalSubscribers  *prometheus.Desc
+	StreamInfo        *prometheus.Desc
+
+	//与 total_*_sum 含义相同，名称以 _total 结尾，OpenMetrics 中才是计数器，可以带 _created 和 exemplar
+	StreamsTotal     *prometheus.Desc
+	ClientsTotal     *prometheus.Desc
+	PublishersTotal  *prometheus.Desc
+	SubscribersTotal *prometheus.Desc
+
+	StreamEvents    *prometheus.Desc
+	Unsubscribes    *prometheus.Desc
+	StreamLifetime  prometheus.Histogram
+	SubscriberAlive prometheus.Histogram
+
+	//引擎事件协程在 OnEvent 中修改计数，抓取协程在 Collect 中读取，由 mu 保护，
+	//Collect 只在复制计数时持有锁，遍历流和输出指标时不阻塞事件
+	mu sync.Mutex
+	mediaCounters
+	//已订阅且尚未取消订阅的订阅者，在线订阅者数目在抓取时由此统计，见 onlineSubscribers
+	subscribers map[engine.ISubscriber]ioKind
+}
+
+// mediaCounters 由引擎事件累加的计数
+type mediaCounters struct {
+	streamEvents     map[string]int64
+	unsubscribeTotal int64
+	//最近一次导致计数器增长的流，作为 OpenMetrics exemplar 输出
+	streamEventSource map[string]exemplarSource
+	unsubscribeSource exemplarSource
+	mediaSource       exemplarSource
+	clientSource      exemplarSource
+	publisherSource   map[ioKind]exemplarSource
+	subscriberSource  map[ioKind]exemplarSource
+	mediaTotal        int64
+	clientTotal       int64
+
+	publisherTotal  map[ioKind]int64
+	subscriberTotal map[ioKind]int64
+}
+
+func copyCounts[K comparable, V any](m map[K]V) map[K]V {
+	result := make(map[K]V, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
+
+// snapshot 返回计数的副本
+func (c *mediaCollectorBasic) snapshot() mediaCounters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counters := c.mediaCounters
+	counters.streamEvents = copyCounts(c.streamEvents)
+	counters.streamEventSource = copyCounts(c.streamEventSource)
+	counters.publisherSource = copyCounts(c.publisherSource)
+	counters.subscriberSource = copyCounts(c.subscriberSource)
+	counters.publisherTotal = copyCounts(c.publisherTotal)
+	counters.subscriberTotal = copyCounts(c.subscriberTotal)
+	return counters
+}
+
+// ioKind 发布者或订阅者的分类，type 为插件/协议类型，class 为远端地址类型
+type ioKind struct {
+	typ   string
+	class string
+}
+
+func newIOKind(io engine.IIO) ioKind {
+	if io == nil {
+		return ioKind{"unknown", "unknown"}
+	}
+	i := io.GetIO()
+	typ := i.Type
+	if typ == "" {
+		typ = "unknown"
+	}
+	return ioKind{typ, addrClass(i.RemoteAddr)}
+}
+
+// addrClass 将远端地址归类为 loopback、private、public 或 unknown
+func addrClass(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "unknown"
+	case ip.IsLoopback():
+		return "loopback"
+	case ip.IsPrivate():
+		return "private"
+	default:
+		return "public"
+	}
+}
+
+type exemplarSource struct {
+	stream string
+	time   time.Time
+}
+
+// withExemplar 为计数器附加引起最近一次增长的流路径
+func withExemplar(m prometheus.Metric, src exemplarSource) prometheus.Metric {
+	if src.stream == "" {
+		return m
+	}
+	em, err := prometheus.NewMetricWithExemplars(m, prometheus.Exemplar{
+		Value:     1,
+		Labels:    prometheus.Labels{"stream": src.stream},
+		Timestamp: src.time,
+	})
+	if err != nil {
+		return m
+	}
+	return em
+}
+
+func (c *mediaCollectorBasic) onStreamEvent(event string, target *engine.Stream) {
+	c.streamEvents[event] += 1
+	if target != nil {
+		c.streamEventSource[event] = exemplarSource{target.Path, time.Now()}
+	}
+}
+
+// onStateEvent 统计流状态变化事件，发布者超时和等待发布超时另外计为 timeout，
+// 无人订阅后的延迟关闭虽然也由超时触发，但属于正常关闭，不计入
+func (c *mediaCollectorBasic) onStateEvent(event string, e engine.StateEvent) {
+	c.onStreamEvent(event, e.Target)
+	if e.Action == engine.ACTION_TIMEOUT && e.From != engine.STATE_WAITCLOSE {
+		c.onStreamEvent("timeout", e.Target)
+	}
 }
 
 func (c *mediaCollectorBasic) OnEvent(event any) {
-	switch event.(type) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch v := event.(type) {
+	case engine.SEcreate:
+		c.onStreamEvent("create", v.Target)
 	case engine.SEpublish:
 		c.mediaTotal += 1
+		c.onStateEvent("publish", v.StateEvent)
+		kind := newIOKind(v.Target.Publisher)
+		c.publisherTotal[kind] += 1
+		c.mediaSource = exemplarSource{v.Target.Path, time.Now()}
+		c.publisherSource[kind] = c.mediaSource
+	case engine.SEwaitPublish:
+		c.onStateEvent("wait_publish", v.StateEvent)
+	case engine.SEwaitClose:
+		c.onStateEvent("wait_close", v.StateEvent)
+	case engine.SEclose:
+		c.onStateEvent("close", v.StateEvent)
+		if v.Target != nil && !v.Target.StartTime.IsZero() {
+			c.StreamLifetime.Observe(time.Since(v.Target.StartTime).Seconds())
+		}
+	case engine.SEKick:
+		c.onStreamEvent("kick", v.Target)
 	case engine.ISubscriber:
 		c.clientTotal += 1
+		kind := newIOKind(v)
+		c.subscriberTotal[kind] += 1
+		c.subscribers[v] = kind
+		if stream := v.GetIO().Stream; stream != nil {
+			c.clientSource = exemplarSource{stream.Path, time.Now()}
+			c.subscriberSource[kind] = c.clientSource
+		}
+	case engine.UnsubscribeEvent:
+		c.unsubscribeTotal += 1
+		if v.Target != nil {
+			delete(c.subscribers, v.Target)
+			io := v.Target.GetIO()
+			if !io.StartTime.IsZero() {
+				c.SubscriberAlive.Observe(time.Since(io.StartTime).Seconds())
+			}
+			if io.Stream != nil {
+				c.unsubscribeSource = exemplarSource{io.Stream.Path, time.Now()}
+			}
+		}
+	}
+}
+
+// onlineSubscribers 按协议类型和远端地址类型统计在线订阅者。流的订阅者列表只能在流自己的协程中访问，
+// 所以这里从订阅事件记录的订阅者出发，逐个检查是否仍然在线：订阅者已经结束或所在的流已经不存在时移除，
+// 即使漏掉了取消订阅事件，数目也不会累积偏差
+func (c *mediaCollectorBasic) onlineSubscribers() map[ioKind]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	online := make(map[ioKind]int64)
+	for sub, kind := range c.subscribers {
+		io := sub.GetIO()
+		if (io.Context != nil && io.Err() != nil) || (io.Stream != nil && engine.Streams.Get(io.Stream.Path) != io.Stream) {
+			delete(c.subscribers, sub)
+			continue
+		}
+		online[kind] += 1
 	}
+	return online
 }
 
 func (c *mediaCollectorBasic) Describe(ch chan<- *prometheus.Desc) {
@@ -39,10 +245,40 @@ func (c *mediaCollectorBasic) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.TotalStreams
 	ch <- c.StreamBps
 	ch <- c.StreamSubscribers
+	ch <- c.TrackBps
+	ch <- c.TrackFps
+	ch <- c.TrackDrops
+	ch <- c.TrackCodec
+	ch <- c.VideoWidth
+	ch <- c.VideoHeight
+	ch <- c.VideoGop
+	ch <- c.KeyframeInterval
+	ch <- c.OnlinePublishers
+	ch <- c.TotalPublishers
+	ch <- c.OnlineSubscribers
+	ch <- c.TotalSubscribers
+	ch <- c.StreamInfo
+	ch <- c.StreamsTotal
+	ch <- c.ClientsTotal
+	ch <- c.PublishersTotal
+	ch <- c.SubscribersTotal
+	ch <- c.StreamEvents
+	ch <- c.Unsubscribes
+	c.StreamLifetime.Describe(ch)
+	c.SubscriberAlive.Describe(ch)
 }
 func (c *mediaCollectorBasic) Collect(ch chan<- prometheus.Metric) {
+	counters := c.snapshot()
 	onlineClientCnt := 0
+	onlinePublishers := make(map[ioKind]int64)
 	engine.Streams.Range(func(name string, ss *engine.Stream) {
+		if ss.Publisher != nil {
+			kind := newIOKind(ss.Publisher)
+			onlinePublishers[kind] += 1
+			ch <- prometheus.MustNewConstMetric(
+				c.StreamInfo, prometheus.GaugeValue, 1, name, kind.typ, kind.class,
+			)
+		}
 		ch <- prometheus.MustNewConstMetric(
 			c.StreamBps, prometheus.GaugeValue, float64(ss.Summary().BPS), name,
 		)
@@ -51,13 +287,16 @@ func (c *mediaCollectorBasic) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(
 			c.StreamSubscribers, prometheus.GaugeValue, float64(clientCnt), name,
 		)
+		ss.Tracks.Range(func(trackName string, t common.Track) {
+			c.collectTrack(ch, name, trackName, t)
+		})
 	})
 
 	ch <- prometheus.MustNewConstMetric(
-		c.TotalStreams, prometheus.CounterValue, float64(c.mediaTotal),
+		c.TotalStreams, prometheus.CounterValue, float64(counters.mediaTotal),
 	)
 	ch <- prometheus.MustNewConstMetric(
-		c.TotalClients, prometheus.CounterValue, float64(c.clientTotal),
+		c.TotalClients, prometheus.CounterValue, float64(counters.clientTotal),
 	)
 	ch <- prometheus.MustNewConstMetric(
 		c.OnlineStreams, prometheus.GaugeValue, float64(engine.Streams.Len()),
@@ -65,9 +304,87 @@ func (c *mediaCollectorBasic) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(
 		c.OnlineClients, prometheus.GaugeValue, float64(onlineClientCnt),
 	)
+	ch <- withExemplar(prometheus.MustNewConstMetric(
+		c.StreamsTotal, prometheus.CounterValue, float64(counters.mediaTotal),
+	), counters.mediaSource)
+	ch <- withExemplar(prometheus.MustNewConstMetric(
+		c.ClientsTotal, prometheus.CounterValue, float64(counters.clientTotal),
+	), counters.clientSource)
+	collectIOKinds(ch, c.OnlinePublishers, prometheus.GaugeValue, onlinePublishers, nil)
+	collectIOKinds(ch, c.TotalPublishers, prometheus.CounterValue, counters.publisherTotal, nil)
+	collectIOKinds(ch, c.PublishersTotal, prometheus.CounterValue, counters.publisherTotal, counters.publisherSource)
+	collectIOKinds(ch, c.OnlineSubscribers, prometheus.GaugeValue, c.onlineSubscribers(), nil)
+	collectIOKinds(ch, c.TotalSubscribers, prometheus.CounterValue, counters.subscriberTotal, nil)
+	collectIOKinds(ch, c.SubscribersTotal, prometheus.CounterValue, counters.subscriberTotal, counters.subscriberSource)
+
+	for event, cnt := range counters.streamEvents {
+		ch <- withExemplar(prometheus.MustNewConstMetric(
+			c.StreamEvents, prometheus.CounterValue, float64(cnt), event,
+		), counters.streamEventSource[event])
+	}
+	ch <- withExemplar(prometheus.MustNewConstMetric(
+		c.Unsubscribes, prometheus.CounterValue, float64(counters.unsubscribeTotal),
+	), counters.unsubscribeSource)
+	c.StreamLifetime.Collect(ch)
+	c.SubscriberAlive.Collect(ch)
+}
+
+// collectIOKinds 按分类输出计数，sources 不为 nil 时附带 exemplar
+func collectIOKinds(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, counts map[ioKind]int64, sources map[ioKind]exemplarSource) {
+	for kind, cnt := range counts {
+		ch <- withExemplar(prometheus.MustNewConstMetric(
+			desc, valueType, float64(cnt), kind.typ, kind.class,
+		), sources[kind])
+	}
+}
+
+func (c *mediaCollectorBasic) collectTrack(ch chan<- prometheus.Metric, streamPath, trackName string, t common.Track) {
+	base := t.GetBase()
+	ch <- prometheus.MustNewConstMetric(
+		c.TrackBps, prometheus.GaugeValue, float64(base.BPS), streamPath, trackName,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.TrackFps, prometheus.GaugeValue, float64(base.FPS), streamPath, trackName,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.TrackDrops, prometheus.CounterValue, float64(base.Drops), streamPath, trackName,
+	)
+	switch t := t.(type) {
+	case *track.Video:
+		ch <- prometheus.MustNewConstMetric(
+			c.TrackCodec, prometheus.GaugeValue, 1, streamPath, trackName, fmt.Sprint(t.CodecID),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.VideoWidth, prometheus.GaugeValue, float64(t.SPSInfo.Width), streamPath, trackName,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.VideoHeight, prometheus.GaugeValue, float64(t.SPSInfo.Height), streamPath, trackName,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.VideoGop, prometheus.GaugeValue, float64(t.GOP), streamPath, trackName,
+		)
+		if base.FPS > 0 {
+			ch <- prometheus.MustNewConstMetric(
+				c.KeyframeInterval, prometheus.GaugeValue, float64(t.GOP)/float64(base.FPS), streamPath, trackName,
+			)
+		}
+	case *track.Audio:
+		ch <- prometheus.MustNewConstMetric(
+			c.TrackCodec, prometheus.GaugeValue, 1, streamPath, trackName, fmt.Sprint(t.CodecID),
+		)
+	}
 }
 
-func newMediaCollector(cfg config.Config) (Collector, error) {
+// OwnedCounters 以 _total 结尾的计数器都由事件从构建时开始累加，丢帧数来自引擎的轨道，不在其中
+func (c *mediaCollectorBasic) OwnedCounters() []string {
+	names := []string{"streams_total", "clients_total", "publishers_total", "subscribers_total", "stream_events_total", "unsubscribe_total"}
+	for i, name := range names {
+		names[i] = prometheus.BuildFQName(Namespace, "media", name)
+	}
+	return names
+}
+
+func newMediaCollector(*NoConfig) (Collector, error) {
 	const subsystem = "media"
 
 	return &mediaCollectorBasic{
@@ -107,5 +424,144 @@ func newMediaCollector(cfg config.Config) (Collector, error) {
 			[]string{"name"},
 			GlobalLabel,
 		),
+		TrackBps: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "track_bps"),
+			"媒体轨道 bps",
+			[]string{"name", "track"},
+			GlobalLabel,
+		),
+		TrackFps: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "track_fps"),
+			"媒体轨道帧率",
+			[]string{"name", "track"},
+			GlobalLabel,
+		),
+		TrackDrops: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "track_dropped_frames_total"),
+			"媒体轨道累计丢帧数",
+			[]string{"name", "track"},
+			GlobalLabel,
+		),
+		TrackCodec: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "track_codec"),
+			"媒体轨道编码格式，值恒为 1",
+			[]string{"name", "track", "codec"},
+			GlobalLabel,
+		),
+		VideoWidth: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "video_width"),
+			"视频宽度(单位像素)",
+			[]string{"name", "track"},
+			GlobalLabel,
+		),
+		VideoHeight: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "video_height"),
+			"视频高度(单位像素)",
+			[]string{"name", "track"},
+			GlobalLabel,
+		),
+		VideoGop: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "video_gop"),
+			"视频 GOP 长度(单位帧)",
+			[]string{"name", "track"},
+			GlobalLabel,
+		),
+		KeyframeInterval: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "video_keyframe_interval"),
+			"视频关键帧间隔(单位秒)",
+			[]string{"name", "track"},
+			GlobalLabel,
+		),
+		OnlinePublishers: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "online_publisher_count"),
+			"在线发布者数目，按协议类型和远端地址类型区分",
+			[]string{"type", "addr_class"},
+			GlobalLabel,
+		),
+		TotalPublishers: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "total_publisher_sum"),
+			"历史发布者总数，按协议类型和远端地址类型区分",
+			[]string{"type", "addr_class"},
+			GlobalLabel,
+		),
+		OnlineSubscribers: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "online_subscriber_count"),
+			"在线订阅者数目，按协议类型和远端地址类型区分",
+			[]string{"type", "addr_class"},
+			GlobalLabel,
+		),
+		TotalSubscribers: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "total_subscriber_sum"),
+			"历史订阅者总数，按协议类型和远端地址类型区分",
+			[]string{"type", "addr_class"},
+			GlobalLabel,
+		),
+		StreamInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "stream_info"),
+			"媒体流发布者信息，值恒为 1",
+			[]string{"name", "publisher_type", "addr_class"},
+			GlobalLabel,
+		),
+		StreamsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "streams_total"),
+			"历史媒体流总数，同 total_stream_sum",
+			nil,
+			GlobalLabel,
+		),
+		ClientsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "clients_total"),
+			"历史客户端总数，同 total_client_sum",
+			nil,
+			GlobalLabel,
+		),
+		PublishersTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "publishers_total"),
+			"历史发布者总数，按协议类型和远端地址类型区分，同 total_publisher_sum",
+			[]string{"type", "addr_class"},
+			GlobalLabel,
+		),
+		SubscribersTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "subscribers_total"),
+			"历史订阅者总数，按协议类型和远端地址类型区分，同 total_subscriber_sum",
+			[]string{"type", "addr_class"},
+			GlobalLabel,
+		),
+		StreamEvents: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "stream_events_total"),
+			"媒体流生命周期事件总数，event 为 create、publish、wait_publish、wait_close、close、timeout、kick，timeout 为发布者超时或等待发布超时",
+			[]string{"event"},
+			GlobalLabel,
+		),
+		Unsubscribes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "unsubscribe_total"),
+			"取消订阅总数",
+			nil,
+			GlobalLabel,
+		),
+		StreamLifetime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   Namespace,
+			Subsystem:   subsystem,
+			Name:        "stream_lifetime_seconds",
+			Help:        "媒体流从创建到关闭的存活时间(单位秒)",
+			ConstLabels: GlobalLabel,
+			Buckets:     []float64{1, 5, 10, 30, 60, 300, 900, 1800, 3600, 4 * 3600, 12 * 3600, 24 * 3600},
+		}),
+		SubscriberAlive: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   Namespace,
+			Subsystem:   subsystem,
+			Name:        "subscriber_session_seconds",
+			Help:        "订阅者从订阅到取消订阅的会话时长(单位秒)",
+			ConstLabels: GlobalLabel,
+			Buckets:     []float64{1, 5, 10, 30, 60, 300, 900, 1800, 3600, 4 * 3600},
+		}),
+		mediaCounters: mediaCounters{
+			streamEvents:      make(map[string]int64),
+			streamEventSource: make(map[string]exemplarSource),
+			publisherSource:   make(map[ioKind]exemplarSource),
+			subscriberSource:  make(map[ioKind]exemplarSource),
+			publisherTotal:    make(map[ioKind]int64),
+			subscriberTotal:   make(map[ioKind]int64),
+		},
+		subscribers: make(map[engine.ISubscriber]ioKind),
 	}, nil
 }
@@ -0,0 +1,243 @@
+package collector
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"m7s.live/engine/v4"
+	"m7s.live/engine/v4/common"
+	"m7s.live/engine/v4/track"
+)
+
+type testSubscriber struct {
+	engine.IO
+}
+
+func gatherValue(t *testing.T, g prometheus.Gatherer, name string, labels map[string]string) float64 {
+	t.Helper()
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if v, ok := labels[l.GetName()]; ok && v != l.GetValue() {
+					continue metrics
+				}
+			}
+			return metricValue(m)
+		}
+	}
+	t.Fatalf("metric %s%v not found", name, labels)
+	return 0
+}
+
+func metricValue(m *dto.Metric) float64 {
+	switch {
+	case m.Counter != nil:
+		return m.Counter.GetValue()
+	case m.Gauge != nil:
+		return m.Gauge.GetValue()
+	case m.Histogram != nil:
+		return float64(m.Histogram.GetSampleCount())
+	}
+	return m.GetUntyped().GetValue()
+}
+
+// TestMediaConcurrentEventsAndCollect 引擎事件和抓取在不同协程中并发进行，需要配合 -race 运行
+func TestMediaConcurrentEventsAndCollect(t *testing.T) {
+	c, err := Build("media", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+
+	const events = 200
+	stream := &engine.Stream{Path: "live/test"}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < events; i++ {
+			c.OnEvent(engine.SEcreate{StreamEvent: engine.StreamEvent{Event: engine.CreateEvent(stream)}})
+			sub := &testSubscriber{engine.IO{Type: "rtmp", RemoteAddr: "127.0.0.1:1935", StartTime: time.Now(), Stream: stream}}
+			c.OnEvent(engine.ISubscriber(sub))
+			c.OnEvent(engine.UnsubscribeEvent{Event: engine.CreateEvent[engine.ISubscriber](sub)})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if _, err := reg.Gather(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"monibuca_media_stream_events_total", map[string]string{"event": "create"}, events},
+		{"monibuca_media_total_client_sum", nil, events},
+		{"monibuca_media_total_subscriber_sum", map[string]string{"type": "rtmp", "addr_class": "loopback"}, events},
+		{"monibuca_media_unsubscribe_total", nil, events},
+		{"monibuca_media_subscriber_session_seconds", nil, events},
+	}
+	for _, tt := range tests {
+		if got := gatherValue(t, reg, tt.name, tt.labels); got != tt.want {
+			t.Errorf("%s%v = %v, want %v", tt.name, tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestMediaStreamEvents(t *testing.T) {
+	stream := &engine.Stream{Path: "live/test"}
+	state := func(action engine.StreamAction, from engine.StreamState) engine.StateEvent {
+		return engine.StateEvent{StreamEvent: engine.StreamEvent{Event: engine.CreateEvent(stream)}, Action: action, From: from}
+	}
+	tests := []struct {
+		name  string
+		event any
+		want  map[string]float64 //各 event 标签的计数
+	}{
+		{"publisher timeout", engine.SEwaitPublish{StateEvent: state(engine.ACTION_TIMEOUT, engine.STATE_PUBLISHING)},
+			map[string]float64{"wait_publish": 1, "timeout": 1}},
+		{"wait publish timeout", engine.SEclose{StateEvent: state(engine.ACTION_TIMEOUT, engine.STATE_WAITPUBLISH)},
+			map[string]float64{"close": 1, "timeout": 1}},
+		{"delayed close", engine.SEclose{StateEvent: state(engine.ACTION_TIMEOUT, engine.STATE_WAITCLOSE)},
+			map[string]float64{"close": 1}},
+		{"last subscriber left", engine.SEwaitClose{StateEvent: state(engine.ACTION_LASTLEAVE, engine.STATE_PUBLISHING)},
+			map[string]float64{"wait_close": 1}},
+		{"kick", engine.SEKick{StreamEvent: engine.StreamEvent{Event: engine.CreateEvent(stream)}},
+			map[string]float64{"kick": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Build("media", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reg := prometheus.NewPedanticRegistry()
+			reg.MustRegister(c)
+			c.OnEvent(tt.event)
+
+			mfs, err := reg.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]float64{}
+			for _, mf := range mfs {
+				if mf.GetName() != "monibuca_media_stream_events_total" {
+					continue
+				}
+				for _, m := range mf.GetMetric() {
+					for _, l := range m.GetLabel() {
+						if l.GetName() == "event" {
+							got[l.GetValue()] = m.GetCounter().GetValue()
+						}
+					}
+					//每个事件都附带流路径作为 exemplar
+					if e := m.GetCounter().GetExemplar(); e == nil || e.GetLabel()[0].GetValue() != stream.Path {
+						t.Errorf("exemplar = %v, want stream %s", e, stream.Path)
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stream events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMediaOnlineSubscribers(t *testing.T) {
+	live := &engine.Stream{Path: "live/online"}
+	closed := &engine.Stream{Path: "live/closed"}
+	engine.Streams.Add(live.Path, live)
+	defer engine.Streams.Delete(live.Path)
+
+	c, err := Build("media", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	subscribe := func(typ string, stream *engine.Stream) *testSubscriber {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		sub := &testSubscriber{engine.IO{Context: ctx, CancelFunc: cancel, Type: typ, RemoteAddr: "127.0.0.1:1935", StartTime: time.Now(), Stream: stream}}
+		c.OnEvent(engine.ISubscriber(sub))
+		return sub
+	}
+	subscribe("rtmp", live)
+	//结束但没有收到取消订阅事件
+	subscribe("rtmp", live).CancelFunc()
+	unsubscribed := subscribe("hls", live)
+	c.OnEvent(engine.UnsubscribeEvent{Event: engine.CreateEvent[engine.ISubscriber](unsubscribed)})
+	//所在的流已经关闭
+	subscribe("hls", closed)
+	subscribe("hls", live)
+
+	tests := []struct {
+		typ  string
+		want float64
+	}{
+		{"rtmp", 1},
+		{"hls", 1},
+	}
+	for _, tt := range tests {
+		labels := map[string]string{"type": tt.typ, "addr_class": "loopback"}
+		if got := gatherValue(t, reg, "monibuca_media_online_subscriber_count", labels); got != tt.want {
+			t.Errorf("online %s subscribers = %v, want %v", tt.typ, got, tt.want)
+		}
+	}
+	if got := gatherValue(t, reg, "monibuca_media_total_subscriber_sum", map[string]string{"type": "hls"}); got != 3 {
+		t.Errorf("total hls subscribers = %v, want 3", got)
+	}
+}
+
+func TestMediaTrackDroppedFrames(t *testing.T) {
+	stream := &engine.Stream{Path: "live/drops"}
+	video := &track.Video{Media: track.Media{Base: common.Base{Name: "h264", Drops: 7}}}
+	stream.Tracks.Add("h264", video)
+	engine.Streams.Add(stream.Path, stream)
+	defer engine.Streams.Delete(stream.Path)
+
+	c, err := Build("media", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "monibuca_media_track_dropped_frames_total" {
+			continue
+		}
+		if mf.GetType() != dto.MetricType_COUNTER {
+			t.Errorf("type = %v, want COUNTER", mf.GetType())
+		}
+		if got := mf.GetMetric()[0].GetCounter().GetValue(); got != 7 {
+			t.Errorf("dropped frames = %v, want 7", got)
+		}
+		return
+	}
+	t.Error("monibuca_media_track_dropped_frames_total not found")
+}
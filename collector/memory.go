@@ -3,11 +3,10 @@ package collector
 import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/shirou/gopsutil/v3/mem"
-	"m7s.live/engine/v4/config"
 )
 
 func init() {
-	RegisterCollector("memory", newMemoryCollector)
+	RegisterTypedCollector("memory", NoConfig{}, newMemoryCollector)
 }
 
 type memoryCollectorBasic struct {
@@ -45,7 +44,7 @@ func (c *memoryCollectorBasic) Collect(ch chan<- prometheus.Metric) {
 	)
 }
 
-func newMemoryCollector(cfg config.Config) (Collector, error) {
+func newMemoryCollector(*NoConfig) (Collector, error) {
 	const subsystem = "memory"
 
 	return &memoryCollectorBasic{
@@ -1,27 +1,27 @@
 package collector
 
 import (
-	"fmt"
 	"github.com/shirou/gopsutil/v3/net"
-	"m7s.live/engine/v4/config"
 	"regexp"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 func init() {
-	RegisterCollector("net", NewNetworkCollector)
+	RegisterTypedCollector("net", netConfig{NicWhitelist: ".*"}, NewNetworkCollector)
 }
 
 var (
 	nicNameToUnderscore = regexp.MustCompile("[^a-zA-Z0-9]")
-	netConfig           = struct {
-		NicWhitelist string
-		NicBlacklist string
-	}{".*", ""}
 )
 
+type netConfig struct {
+	NicWhitelist string `desc:"需要统计的网卡，支持正则表达式，默认所有"`
+	NicBlacklist string `desc:"不统计的网卡，支持正则表达式，默认不排除"`
+}
+
 type netInfo struct {
 	net.IOCountersStat
 	ReceiveSpeed float64
@@ -48,6 +48,7 @@ type NetworkCollector struct {
 	nicWhitelistPattern *regexp.Regexp
 	nicBlacklistPattern *regexp.Regexp
 
+	mu          sync.Mutex //保护 lastNetWork 和 lastTime，并发的多次采集都会计算速度
 	lastNetWork map[string]*netInfo
 	lastTime    time.Time
 }
@@ -76,24 +77,13 @@ func (c *NetworkCollector) Describe(ch chan<- *prometheus.Desc) {
 
 func (c *NetworkCollector) Collect(ch chan<- prometheus.Metric) {
 	nv, _ := net.IOCounters(true)
-	now := time.Now()
+	speeds := c.updateSpeeds(nv, time.Now())
 	for _, nic := range nv {
-		if c.nicBlacklistPattern.MatchString(nic.Name) ||
-			!c.nicWhitelistPattern.MatchString(nic.Name) {
+		speed, ok := speeds[nic.Name]
+		if !ok {
 			continue
 		}
 
-		if ni, exist := c.lastNetWork[nic.Name]; exist {
-			delta := now.Sub(c.lastTime).Seconds()
-
-			ni.ReceiveSpeed = float64(nic.BytesRecv-c.lastNetWork[nic.Name].BytesRecv) / delta
-			ni.SentSpeed = float64(nic.BytesSent-c.lastNetWork[nic.Name].BytesSent) / delta
-			ni.IOCountersStat = nic
-		} else {
-			c.lastNetWork[nic.Name] = &netInfo{IOCountersStat: nic}
-		}
-		c.lastTime = now
-
 		ch <- prometheus.MustNewConstMetric(
 			c.BytesReceivedTotal,
 			prometheus.CounterValue,
@@ -116,13 +106,13 @@ func (c *NetworkCollector) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(
 			c.BytesReceiveSpeed,
 			prometheus.GaugeValue,
-			c.lastNetWork[nic.Name].ReceiveSpeed,
+			speed.ReceiveSpeed,
 			nic.Name,
 		)
 		ch <- prometheus.MustNewConstMetric(
 			c.BytesSentSpeed,
 			prometheus.GaugeValue,
-			c.lastNetWork[nic.Name].SentSpeed,
+			speed.SentSpeed,
 			nic.Name,
 		)
 
@@ -167,10 +157,49 @@ func (c *NetworkCollector) Collect(ch chan<- prometheus.Metric) {
 
 }
 
-func NewNetworkCollector(cfg config.Config) (Collector, error) {
+// updateSpeeds 根据上次采集的计数计算白名单内每个网卡的速度，返回计算结果的副本
+func (c *NetworkCollector) updateSpeeds(nv []net.IOCountersStat, now time.Time) map[string]netInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delta := now.Sub(c.lastTime).Seconds()
+	//并发采集时较早读取的计数可能后处理，此时只返回已有的结果，不更新状态
+	update := c.lastTime.IsZero() || delta > 0
+	speeds := make(map[string]netInfo, len(nv))
+	for _, nic := range nv {
+		if c.nicBlacklistPattern.MatchString(nic.Name) ||
+			!c.nicWhitelistPattern.MatchString(nic.Name) {
+			continue
+		}
+		ni, exist := c.lastNetWork[nic.Name]
+		switch {
+		case !update:
+		case exist:
+			ni.ReceiveSpeed = float64(nic.BytesRecv-ni.BytesRecv) / delta
+			ni.SentSpeed = float64(nic.BytesSent-ni.BytesSent) / delta
+			ni.IOCountersStat = nic
+		default:
+			ni = &netInfo{IOCountersStat: nic}
+			c.lastNetWork[nic.Name] = ni
+		}
+		if ni != nil {
+			speeds[nic.Name] = *ni
+		}
+	}
+	if update {
+		c.lastTime = now
+	}
+	return speeds
+}
+
+func NewNetworkCollector(conf *netConfig) (Collector, error) {
 	const subsystem = "net"
-	if cfg != nil {
-		cfg.Unmarshal(&netConfig)
+	nicWhitelistPattern, err := compilePattern("nicwhitelist", conf.NicWhitelist)
+	if err != nil {
+		return nil, err
+	}
+	nicBlacklistPattern, err := compilePattern("nicblacklist", conf.NicBlacklist)
+	if err != nil {
+		return nil, err
 	}
 	return &NetworkCollector{
 		BytesReceivedTotal: prometheus.NewDesc(
@@ -243,8 +272,8 @@ func NewNetworkCollector(cfg config.Config) (Collector, error) {
 			nil,
 		),
 
-		nicWhitelistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", netConfig.NicWhitelist)),
-		nicBlacklistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", netConfig.NicBlacklist)),
+		nicWhitelistPattern: nicWhitelistPattern,
+		nicBlacklistPattern: nicBlacklistPattern,
 		lastNetWork:         make(map[string]*netInfo),
 	}, nil
 }
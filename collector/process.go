@@ -0,0 +1,221 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shirou/gopsutil/v3/process"
+	"os"
+	"runtime"
+	"sync"
+)
+
+func init() {
+	RegisterTypedCollector("process", NoConfig{}, newProcessCollector)
+}
+
+// gcPauseBuckets GC 停顿时间直方图的分桶(单位秒)
+var gcPauseBuckets = []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1}
+
+type processCollectorBasic struct {
+	Goroutines      *prometheus.Desc
+	Threads         *prometheus.Desc
+	HeapBytes       *prometheus.Desc
+	GcPause         *prometheus.Desc
+	OpenFds         *prometheus.Desc
+	MaxFds          *prometheus.Desc
+	ContextSwitches *prometheus.Desc
+	IOBytes         *prometheus.Desc
+	CpuSeconds      *prometheus.Desc
+
+	pid int
+
+	//GC 停顿直方图的累计状态，MemStats 只保留最近 256 次停顿，所以每次采集时把新增的部分累加进来，
+	//并发的多次采集由 mu 保护
+	mu           sync.Mutex
+	lastNumGC    uint32
+	pauseBuckets []uint64
+	pauseCount   uint64
+	pauseSum     float64
+}
+
+func (c *processCollectorBasic) OnEvent(event any) {
+
+}
+
+func (c *processCollectorBasic) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.Goroutines
+	ch <- c.Threads
+	ch <- c.HeapBytes
+	ch <- c.GcPause
+	ch <- c.OpenFds
+	ch <- c.MaxFds
+	ch <- c.ContextSwitches
+	ch <- c.IOBytes
+	ch <- c.CpuSeconds
+}
+
+func (c *processCollectorBasic) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(
+		c.Goroutines, prometheus.GaugeValue, float64(runtime.NumGoroutine()),
+	)
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	ch <- prometheus.MustNewConstMetric(
+		c.HeapBytes, prometheus.GaugeValue, float64(ms.HeapInuse), "inuse",
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.HeapBytes, prometheus.GaugeValue, float64(ms.HeapIdle), "idle",
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.HeapBytes, prometheus.GaugeValue, float64(ms.HeapReleased), "released",
+	)
+	count, sum, buckets := c.observeGCPauses(&ms)
+	ch <- prometheus.MustNewConstHistogram(
+		c.GcPause, count, sum, buckets,
+	)
+
+	m7sProcess, err := process.NewProcess(int32(c.pid))
+	if err != nil {
+		return
+	}
+	if threads, err := m7sProcess.NumThreads(); err == nil {
+		ch <- prometheus.MustNewConstMetric(
+			c.Threads, prometheus.GaugeValue, float64(threads),
+		)
+	}
+	if fds, err := m7sProcess.NumFDs(); err == nil {
+		ch <- prometheus.MustNewConstMetric(
+			c.OpenFds, prometheus.GaugeValue, float64(fds),
+		)
+	}
+	if limits, err := m7sProcess.Rlimit(); err == nil {
+		for _, limit := range limits {
+			if limit.Resource == process.RLIMIT_NOFILE {
+				ch <- prometheus.MustNewConstMetric(
+					c.MaxFds, prometheus.GaugeValue, float64(limit.Soft),
+				)
+			}
+		}
+	}
+	if switches, err := m7sProcess.NumCtxSwitches(); err == nil {
+		ch <- prometheus.MustNewConstMetric(
+			c.ContextSwitches, prometheus.CounterValue, float64(switches.Voluntary), "voluntary",
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.ContextSwitches, prometheus.CounterValue, float64(switches.Involuntary), "involuntary",
+		)
+	}
+	if io, err := m7sProcess.IOCounters(); err == nil {
+		ch <- prometheus.MustNewConstMetric(
+			c.IOBytes, prometheus.CounterValue, float64(io.ReadBytes), "read",
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.IOBytes, prometheus.CounterValue, float64(io.WriteBytes), "write",
+		)
+	}
+	if cpuTime, err := m7sProcess.Times(); err == nil {
+		ch <- prometheus.MustNewConstMetric(
+			c.CpuSeconds, prometheus.CounterValue, cpuTime.User, "user",
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.CpuSeconds, prometheus.CounterValue, cpuTime.System, "system",
+		)
+	}
+}
+
+// observeGCPauses 把上次采集之后新增的 GC 停顿累加到直方图中，返回累加后的直方图
+func (c *processCollectorBasic) observeGCPauses(ms *runtime.MemStats) (count uint64, sum float64, buckets map[float64]uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ms.NumGC < c.lastNumGC {
+		//并发采集时较早读取的 MemStats 可能后处理，已经累加过
+		return c.pauseHistogram()
+	}
+	n := ms.NumGC - c.lastNumGC
+	if n > uint32(len(ms.PauseNs)) {
+		n = uint32(len(ms.PauseNs))
+	}
+	for i := uint32(0); i < n; i++ {
+		pause := float64(ms.PauseNs[(ms.NumGC-i+255)%256]) / 1e9
+		c.pauseCount++
+		c.pauseSum += pause
+		for j, upper := range gcPauseBuckets {
+			if pause <= upper {
+				c.pauseBuckets[j]++
+			}
+		}
+	}
+	c.lastNumGC = ms.NumGC
+	return c.pauseHistogram()
+}
+
+func (c *processCollectorBasic) pauseHistogram() (uint64, float64, map[float64]uint64) {
+	buckets := make(map[float64]uint64, len(gcPauseBuckets))
+	for i, upper := range gcPauseBuckets {
+		buckets[upper] = c.pauseBuckets[i]
+	}
+	return c.pauseCount, c.pauseSum, buckets
+}
+
+func newProcessCollector(*NoConfig) (Collector, error) {
+	const subsystem = "process"
+
+	return &processCollectorBasic{
+		Goroutines: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "goroutines"),
+			"Monibuca 协程数",
+			nil,
+			GlobalLabel,
+		),
+		Threads: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "threads"),
+			"Monibuca 线程数",
+			nil,
+			GlobalLabel,
+		),
+		HeapBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "heap_bytes"),
+			"Monibuca 堆内存(单位字节)，state 为 inuse、idle、released",
+			[]string{"state"},
+			GlobalLabel,
+		),
+		GcPause: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "gc_pause_seconds"),
+			"Monibuca GC 停顿时间(单位秒)",
+			nil,
+			GlobalLabel,
+		),
+		OpenFds: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "open_fds"),
+			"Monibuca 打开的文件描述符数目",
+			nil,
+			GlobalLabel,
+		),
+		MaxFds: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "max_fds"),
+			"Monibuca 文件描述符数目上限",
+			nil,
+			GlobalLabel,
+		),
+		ContextSwitches: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "context_switches_total"),
+			"Monibuca 上下文切换次数",
+			[]string{"type"},
+			GlobalLabel,
+		),
+		IOBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "io_bytes_total"),
+			"Monibuca 磁盘读写字节数",
+			[]string{"direction"},
+			GlobalLabel,
+		),
+		CpuSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "cpu_seconds_total"),
+			"Monibuca Cpu时间(单位秒)",
+			[]string{"mode"},
+			GlobalLabel,
+		),
+		pid:          os.Getpid(),
+		pauseBuckets: make([]uint64, len(gcPauseBuckets)),
+	}, nil
+}
@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+// Serialized 包装没有自行加锁的采集器，框架保证它的 OnEvent、Describe 和 Collect 不会并发执行，
+// 采集器可以像在单个协程中一样直接读写自己的状态，Runner 的 Run 不在串行化的范围内。
+// 采集进行中到达的事件先排队，由持有锁的一方在释放前按顺序处理，OnEvent 不会等待采集，
+// 采集卡住时事件会一直排队，直到采集结束
+func Serialized(c Collector) Collector {
+	return &serializedCollector{Collector: c}
+}
+
+type serializedCollector struct {
+	Collector
+	mu      sync.Mutex //持有时才能调用被包装的采集器
+	queueMu sync.Mutex
+	queue   []any
+}
+
+// lock 获取锁并处理排队的事件
+func (c *serializedCollector) lock() {
+	c.mu.Lock()
+	c.drain()
+}
+
+// unlock 处理排队的事件后释放锁，释放后如果又有事件排队并且没有其它协程持有锁，继续处理
+func (c *serializedCollector) unlock() {
+	for {
+		c.drain()
+		c.mu.Unlock()
+		c.queueMu.Lock()
+		pending := len(c.queue) > 0
+		c.queueMu.Unlock()
+		if !pending || !c.mu.TryLock() {
+			return
+		}
+	}
+}
+
+func (c *serializedCollector) drain() {
+	for {
+		c.queueMu.Lock()
+		events := c.queue
+		c.queue = nil
+		c.queueMu.Unlock()
+		if len(events) == 0 {
+			return
+		}
+		for _, event := range events {
+			c.Collector.OnEvent(event)
+		}
+	}
+}
+
+func (c *serializedCollector) OnEvent(event any) {
+	c.queueMu.Lock()
+	c.queue = append(c.queue, event)
+	c.queueMu.Unlock()
+	if c.mu.TryLock() {
+		c.unlock()
+	}
+}
+
+func (c *serializedCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.lock()
+	defer c.unlock()
+	c.Collector.Describe(ch)
+}
+
+// Collect 在锁内把指标收集到缓冲中，释放锁后再输出，抓取方读取得慢时不会推迟事件的处理
+func (c *serializedCollector) Collect(ch chan<- prometheus.Metric) {
+	buf := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range buf {
+			metrics = append(metrics, m)
+		}
+		done <- metrics
+	}()
+	c.lock()
+	c.Collector.Collect(buf)
+	c.unlock()
+	close(buf)
+	for _, m := range <-done {
+		ch <- m
+	}
+}
@@ -0,0 +1,128 @@
+package collector
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"m7s.live/engine/v4/config"
+)
+
+// unlockedCollector 没有任何锁，直接在 OnEvent 和 Collect 中读写 map
+type unlockedCollector struct {
+	desc    *prometheus.Desc
+	counts  map[string]int
+	block   chan struct{} //不为 nil 时 Collect 等待它关闭
+	started chan struct{}
+}
+
+func (c *unlockedCollector) Describe(ch chan<- *prometheus.Desc) { ch <- c.desc }
+
+func (c *unlockedCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.block != nil {
+		close(c.started)
+		<-c.block
+	}
+	for k, v := range c.counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(v), k)
+	}
+}
+
+func (c *unlockedCollector) OnEvent(event any) { c.counts[event.(string)]++ }
+
+func newUnlockedCollector(t *testing.T, name string, block chan struct{}) *unlockedCollector {
+	c := &unlockedCollector{
+		desc:    prometheus.NewDesc(name, "test", []string{"event"}, nil),
+		counts:  make(map[string]int),
+		block:   block,
+		started: make(chan struct{}),
+	}
+	RegisterCollector(name, func(config.Config) (Collector, error) { return Serialized(c), nil })
+	t.Cleanup(func() { delete(builders, name) })
+	return c
+}
+
+// TestSerializedConcurrentEventsAndCollect 需要配合 -race 运行
+func TestSerializedConcurrentEventsAndCollect(t *testing.T) {
+	newUnlockedCollector(t, "test_serialized", nil)
+	c, err := Build("test_serialized", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+
+	const events = 500
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < events; j++ {
+				c.OnEvent(fmt.Sprint("e", j%5))
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := reg.Gather(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 5; i++ {
+		labels := map[string]string{"event": fmt.Sprint("e", i)}
+		if got := gatherValue(t, reg, "test_serialized", labels); got != 2*events/5 {
+			t.Errorf("count %v = %v, want %v", labels, got, 2*events/5)
+		}
+	}
+}
+
+// TestSerializedEventsDoNotWaitForCollect 采集进行中 OnEvent 立即返回，事件在采集结束后按顺序生效
+func TestSerializedEventsDoNotWaitForCollect(t *testing.T) {
+	block := make(chan struct{})
+	inner := newUnlockedCollector(t, "test_serialized_blocking", block)
+	c := Serialized(inner)
+	collected := make(chan struct{})
+	go func() {
+		ch := make(chan prometheus.Metric, 10)
+		c.Collect(ch)
+		close(collected)
+	}()
+	<-inner.started
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			c.OnEvent("e")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("OnEvent waited for Collect")
+	}
+	close(block)
+	<-collected
+
+	ch := make(chan prometheus.Metric, 10)
+	inner.block = nil
+	c.Collect(ch)
+	if len(ch) != 1 {
+		t.Fatalf("got %d metrics, want 1", len(ch))
+	}
+	var m dto.Metric
+	if err := (<-ch).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := metricValue(&m); got != 3 {
+		t.Errorf("count = %v, want 3", got)
+	}
+}
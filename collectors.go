@@ -0,0 +1,120 @@
+package exporter
+
+import (
+	"encoding/json"
+	"m7s.live/plugin/exporter/v4/collector"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type collectorStatus struct {
+	Name                string     `json:"name"`
+	Enabled             bool       `json:"enabled"`
+	BuildError          string     `json:"buildError,omitempty"`
+	LastCollectAt       *time.Time `json:"lastCollectAt,omitempty"`
+	LastDurationSeconds float64    `json:"lastDurationSeconds"`
+	LastError           string     `json:"lastError,omitempty"`
+}
+
+// collectorStatus 返回采集器的启用状态、构建错误以及最近一次采集的耗时和错误
+func (p *ExporterConfig) collectorStatus(name string) collectorStatus {
+	p.mu.RLock()
+	c, enabled := p.collectors[name]
+	buildErr := p.buildErrors[name]
+	p.mu.RUnlock()
+	status := collectorStatus{Name: name, Enabled: enabled}
+	if buildErr != nil {
+		status.BuildError = buildErr.Error()
+	}
+	if !enabled {
+		return status
+	}
+	if stats, ok := collector.StatsOf(c); ok && !stats.Time.IsZero() {
+		status.LastCollectAt = &stats.Time
+		status.LastDurationSeconds = stats.Duration.Seconds()
+		if stats.Err != nil {
+			status.LastError = stats.Err.Error()
+		}
+	}
+	return status
+}
+
+// setEnabled 在 Enabled 中加入或移除采集器并重新加载，[defaults] 会被展开，保持 Enabled 与实际启用的采集器一致
+func (p *ExporterConfig) setEnabled(name string, enabled bool) {
+	p.mu.Lock()
+	names := []string{}
+	for _, n := range expandEnabledCollectors(p.Enabled) {
+		if n != name {
+			names = append(names, n)
+		}
+	}
+	if enabled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	p.Enabled = strings.Join(names, ",")
+	p.mu.Unlock()
+	p.reload()
+}
+
+// API_collectors GET 列出所有可用的采集器及其状态，PUT 启用、DELETE 停用 name 参数指定的采集器
+func (p *ExporterConfig) API_collectors(w http.ResponseWriter, r *http.Request) {
+	if !p.accept(w, r) {
+		return
+	}
+	available := collector.Available()
+	sort.Strings(available)
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		result := make([]collectorStatus, 0, len(available))
+		for _, name := range available {
+			result = append(result, p.collectorStatus(name))
+		}
+		json.NewEncoder(w).Encode(map[string]any{"collectors": result})
+	case http.MethodPut, http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if i := sort.SearchStrings(available, name); i == len(available) || available[i] != name {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]any{"error": "unknown collector " + name})
+			return
+		}
+		p.setEnabled(name, r.Method == http.MethodPut)
+		status := p.collectorStatus(name)
+		if r.Method == http.MethodPut && !status.Enabled {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(status)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// API_config_schema 返回每个采集器的配置项，包括名称、类型、默认值和说明，可用 collector 参数只返回部分采集器
+func (p *ExporterConfig) API_config_schema(w http.ResponseWriter, r *http.Request) {
+	if !p.accept(w, r) {
+		return
+	}
+	names := r.URL.Query()["collector"]
+	if len(names) == 0 {
+		names = collector.Available()
+	}
+	p.mu.RLock()
+	timeout := p.Timeout
+	p.mu.RUnlock()
+	result := make(map[string][]collector.Option, len(names))
+	for _, name := range names {
+		schema, ok := collector.Schema(name, timeout)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("unknown collector " + name))
+			return
+		}
+		result[name] = schema
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"collectors": result})
+}
@@ -0,0 +1,100 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"m7s.live/engine/v4/config"
+	"m7s.live/plugin/exporter/v4/collector"
+)
+
+func TestAPICollectorsToggle(t *testing.T) {
+	p := newTestExporter("base,test_reload_a")
+	gathered := func(name string) bool {
+		mfs, err := p.gatherer.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, mf := range mfs {
+			if mf.GetName() == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	steps := []struct {
+		method  string
+		name    string
+		code    int
+		enabled bool
+	}{
+		{http.MethodDelete, "base", http.StatusOK, false},
+		{http.MethodPut, "base", http.StatusOK, true},
+		{http.MethodDelete, "base", http.StatusOK, false},
+		{http.MethodPut, "base", http.StatusOK, true},
+		{http.MethodPut, "not_exist", http.StatusNotFound, false},
+	}
+	for _, step := range steps {
+		rec := httptest.NewRecorder()
+		p.API_collectors(rec, httptest.NewRequest(step.method, "/exporter/api/collectors?name="+step.name, nil))
+		if rec.Code != step.code {
+			t.Fatalf("%s %s: status %d, want %d: %s", step.method, step.name, rec.Code, step.code, rec.Body)
+		}
+		if step.code != http.StatusOK {
+			continue
+		}
+		var status collectorStatus
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		if status.Enabled != step.enabled || status.BuildError != "" {
+			t.Errorf("%s %s: status %+v, want enabled %v", step.method, step.name, status, step.enabled)
+		}
+		//停用后 base 的所有指标，包括 info 和 os，都不再输出
+		for _, metric := range []string{"monibuca_base_info", "monibuca_base_os", "monibuca_base_running_time"} {
+			if gathered(metric) != step.enabled {
+				t.Errorf("%s %s: %s gathered = %v, want %v", step.method, step.name, metric, !step.enabled, step.enabled)
+			}
+		}
+		if !gathered("test_reload_a") {
+			t.Errorf("%s %s: other collectors are affected", step.method, step.name)
+		}
+	}
+}
+
+// TestAPIConfigSchemaDuringReload 配置变更热加载的同时查询配置说明，需要配合 -race 运行
+func TestAPIConfigSchemaDuringReload(t *testing.T) {
+	p := newTestExporter("test_reload_a")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 20; i++ {
+			p.applyConfig(config.Config{"timeout": fmt.Sprintf("%ds", i)})
+			p.reload()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		rec := httptest.NewRecorder()
+		p.API_config_schema(rec, httptest.NewRequest(http.MethodGet, "/exporter/api/config/schema?collector=test_reload_a", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+	}
+	<-done
+
+	rec := httptest.NewRecorder()
+	p.API_config_schema(rec, httptest.NewRequest(http.MethodGet, "/exporter/api/config/schema?collector=test_reload_a", nil))
+	var resp struct {
+		Collectors map[string][]collector.Option
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if schema := resp.Collectors["test_reload_a"]; len(schema) == 0 || schema[0].Name != "timeout" || schema[0].Default != "20s" {
+		t.Errorf("schema = %+v, want timeout default 20s", schema)
+	}
+}
@@ -0,0 +1,73 @@
+package exporter
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+//go:embed ui/index.html
+var dashboardHTML []byte
+
+// dashboardMetrics 内置看板展示的指标，插件会在内存中保留它们最近的历史
+var dashboardMetrics = map[string]bool{
+	"monibuca_cpu_usage":                 true,
+	"monibuca_memory_used_percent":       true,
+	"monibuca_disk_used_percent":         true,
+	"monibuca_net_bytes_received_speed":  true,
+	"monibuca_net_bytes_sent_speed":      true,
+	"monibuca_media_stream_bps":          true,
+	"monibuca_media_stream_client_count": true,
+}
+
+// ServeHTTP 在 /exporter/ 下提供内置看板，页面和脚本都内嵌在程序中，无需访问外网
+func (p *ExporterConfig) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.accept(w, r) {
+		return
+	}
+	switch r.URL.Path {
+	case "/exporter/", "/exporter/index.html", "/", "/index.html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardHTML)
+	case "/exporter/api/query_range", "/api/query_range":
+		p.queryRange(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type dashboardSeries struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+	Points [][2]float64      `json:"points"`
+}
+
+// API_dashboard 返回看板指标最近的历史，points 为 [毫秒时间戳, 值]
+func (p *ExporterConfig) API_dashboard(w http.ResponseWriter, r *http.Request) {
+	if !p.accept(w, r) {
+		return
+	}
+	h := p.history
+	h.mu.RLock()
+	series := make([]dashboardSeries, 0, len(h.series))
+	for _, s := range h.series {
+		if !dashboardMetrics[s.name] {
+			continue
+		}
+		ds := dashboardSeries{Name: s.name, Labels: make(map[string]string), Points: [][2]float64{}}
+		for _, l := range s.labels {
+			ds.Labels[l.Name] = l.Value
+		}
+		for _, pt := range h.points(s, 0, time.Now().UnixMilli()) {
+			ds.Points = append(ds.Points, [2]float64{float64(pt.Timestamp), pt.Value})
+		}
+		series = append(series, ds)
+	}
+	h.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"interval": h.interval.Seconds(),
+		"series":   series,
+	})
+}
@@ -3,8 +3,13 @@ module m7s.live/plugin/exporter/v4
 go 1.18
 
 require (
+	github.com/golang/snappy v0.0.4
 	github.com/prometheus/client_golang v1.14.0
+	github.com/prometheus/client_model v0.3.0
+	github.com/prometheus/common v0.37.0
 	github.com/shirou/gopsutil/v3 v3.22.11
+	golang.org/x/crypto v0.0.0-20220516162934-403b01795ae8
+	google.golang.org/protobuf v1.28.1
 	m7s.live/engine/v4 v4.8.8
 )
 
@@ -30,8 +35,6 @@ require (
 	github.com/pion/rtp v1.7.13 // indirect
 	github.com/pion/webrtc/v3 v3.1.44 // indirect
 	github.com/power-devops/perfstat v0.0.0-20210106213030-5aafc221ea8c // indirect
-	github.com/prometheus/client_model v0.3.0 // indirect
-	github.com/prometheus/common v0.37.0 // indirect
 	github.com/prometheus/procfs v0.8.0 // indirect
 	github.com/q191201771/naza v0.19.1 // indirect
 	github.com/tklauser/go-sysconf v0.3.11 // indirect
@@ -40,7 +43,6 @@ require (
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
 	go.uber.org/zap v1.21.0 // indirect
-	golang.org/x/crypto v0.0.0-20220516162934-403b01795ae8 // indirect
 	golang.org/x/exp v0.0.0-20220722155223-a9213eeb770e // indirect
 	golang.org/x/mod v0.6.0-dev.0.20220106191415-9b9b3d81d5e3 // indirect
 	golang.org/x/net v0.0.0-20220630215102-69896b714898 // indirect
@@ -48,7 +50,6 @@ require (
 	golang.org/x/sys v0.2.0 // indirect
 	golang.org/x/tools v0.1.10 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
-	google.golang.org/protobuf v1.28.1 // indirect
 	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
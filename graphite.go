@@ -0,0 +1,148 @@
+package exporter
+
+import (
+	"bufio"
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"m7s.live/engine/v4/log"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type GraphiteConfig struct {
+	Addr       string        //Carbon 的 plaintext 地址，比如 127.0.0.1:2003，为空不发送
+	Prefix     string        //路径前缀，比如 servers.
+	LabelOrder string        //标签值在路径中的顺序，逗号分隔，未列出的标签按名称排序排在后面
+	Tagged     bool          //是否以 Graphite 1.1 的 tag 格式(name;tag=value)发送标签，否则标签值会拼接到路径中
+	Interval   time.Duration //发送间隔
+	Timeout    time.Duration //连接和写入超时
+}
+
+func (c GraphiteConfig) validate() error {
+	if err := checkPositive("interval", c.Interval); err != nil {
+		return err
+	}
+	return checkPositive("timeout", c.Timeout)
+}
+
+var (
+	graphiteSanitizer = regexp.MustCompile(`[^a-zA-Z0-9_\-]`)
+	//tag 的值不能包含 ; 和空白，也不能以 ~ 开头
+	graphiteTagValueSanitizer = regexp.MustCompile(`[;~\s]`)
+)
+
+type graphiteWriter struct {
+	conf       GraphiteConfig
+	g          prometheus.Gatherer
+	labelOrder map[string]int
+	conn       net.Conn
+}
+
+func newGraphiteWriter(conf GraphiteConfig, g prometheus.Gatherer) *graphiteWriter {
+	w := &graphiteWriter{
+		conf:       conf,
+		g:          g,
+		labelOrder: make(map[string]int),
+	}
+	for i, name := range strings.Split(conf.LabelOrder, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			w.labelOrder[name] = i + 1
+		}
+	}
+	return w
+}
+
+// path 把指标名和标签值展开为 Graphite 路径，指标名的前两个下划线(namespace、subsystem)替换为点，
+// 比如 monibuca_media_stream_bps{name="live/test"} 展开为 monibuca.media.stream_bps.live_test，
+// 标签值中的 / 和 . 等字符替换为下划线；tagged 时标签以 ;name=value 的形式按名称顺序附加在后面，
+// 比如 monibuca.media.stream_bps;name=live/test
+func (w *graphiteWriter) path(s sample) string {
+	var b strings.Builder
+	b.WriteString(w.conf.Prefix)
+	b.WriteString(strings.Replace(s.Name, "_", ".", 2))
+	if w.conf.Tagged {
+		for _, l := range s.Labels {
+			if l.Value == "" {
+				continue
+			}
+			b.WriteByte(';')
+			b.WriteString(graphiteSanitizer.ReplaceAllString(l.Name, "_"))
+			b.WriteByte('=')
+			b.WriteString(graphiteTagValueSanitizer.ReplaceAllString(l.Value, "_"))
+		}
+		return b.String()
+	}
+	labels := append([]labelPair{}, s.Labels...)
+	order := func(name string) int {
+		if i, ok := w.labelOrder[name]; ok {
+			return i
+		}
+		return len(w.labelOrder) + 1
+	}
+	//s.Labels 已按名称排序，稳定排序后未列出的标签保持名称顺序
+	sort.SliceStable(labels, func(i, j int) bool { return order(labels[i].Name) < order(labels[j].Name) })
+	for _, l := range labels {
+		if l.Value == "" {
+			continue
+		}
+		b.WriteByte('.')
+		b.WriteString(graphiteSanitizer.ReplaceAllString(l.Value, "_"))
+	}
+	return b.String()
+}
+
+// run 按发送间隔定时发送，连接断开后在下一次发送时重连，直到 ctx 取消
+func (w *graphiteWriter) run(ctx context.Context) {
+	ticker := time.NewTicker(w.conf.Interval)
+	defer ticker.Stop()
+	defer func() {
+		if w.conn != nil {
+			w.conn.Close()
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := w.write(now); err != nil {
+				log.Warn("Exporter write to graphite err: ", err)
+				if w.conn != nil {
+					w.conn.Close()
+					w.conn = nil
+				}
+			}
+		}
+	}
+}
+
+func (w *graphiteWriter) write(now time.Time) (err error) {
+	if w.conn == nil {
+		if w.conn, err = net.DialTimeout("tcp", w.conf.Addr, w.conf.Timeout); err != nil {
+			return err
+		}
+	}
+	mfs, err := w.g.Gather()
+	if err != nil {
+		log.Warn("Exporter graphite gather err: ", err)
+	}
+	w.conn.SetWriteDeadline(now.Add(w.conf.Timeout))
+	buf := bufio.NewWriter(w.conn)
+	for _, s := range flatten(mfs, now) {
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+		buf.WriteString(w.path(s))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatFloat(s.Value, 'f', -1, 64))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatInt(s.Timestamp/1000, 10))
+		buf.WriteByte('\n')
+	}
+	return buf.Flush()
+}
@@ -0,0 +1,41 @@
+package exporter
+
+import (
+	"testing"
+)
+
+func TestGraphitePath(t *testing.T) {
+	labels := []labelPair{{"hostname", "host.1"}, {"name", "live/test"}, {"nodeaddr", "zh_cn"}}
+	tests := []struct {
+		name string
+		conf GraphiteConfig
+		s    sample
+		want string
+	}{
+		{"no labels", GraphiteConfig{}, sample{Name: "monibuca_media_stream_bps"}, "monibuca.media.stream_bps"},
+		{"dotted sorted by name", GraphiteConfig{}, sample{Name: "monibuca_media_stream_bps", Labels: labels},
+			"monibuca.media.stream_bps.host_1.live_test.zh_cn"},
+		{"dotted label order", GraphiteConfig{LabelOrder: "name"}, sample{Name: "monibuca_media_stream_bps", Labels: labels},
+			"monibuca.media.stream_bps.live_test.host_1.zh_cn"},
+		{"dotted full label order", GraphiteConfig{LabelOrder: " nodeaddr, name ,hostname"}, sample{Name: "monibuca_media_stream_bps", Labels: labels},
+			"monibuca.media.stream_bps.zh_cn.live_test.host_1"},
+		{"prefix", GraphiteConfig{Prefix: "servers."}, sample{Name: "monibuca_cpu_usage"}, "servers.monibuca.cpu.usage"},
+		{"sanitize value", GraphiteConfig{}, sample{Name: "monibuca_disk_used", Labels: []labelPair{{"path", "/data dir;x=1"}}},
+			"monibuca.disk.used._data_dir_x_1"},
+		{"skip empty value", GraphiteConfig{}, sample{Name: "monibuca_disk_used", Labels: []labelPair{{"device", ""}, {"path", "/"}}},
+			"monibuca.disk.used._"},
+		{"tagged", GraphiteConfig{Tagged: true}, sample{Name: "monibuca_media_stream_bps", Labels: labels},
+			"monibuca.media.stream_bps;hostname=host.1;name=live/test;nodeaddr=zh_cn"},
+		{"tagged ignores label order", GraphiteConfig{Tagged: true, LabelOrder: "name"}, sample{Name: "monibuca_media_stream_bps", Labels: labels},
+			"monibuca.media.stream_bps;hostname=host.1;name=live/test;nodeaddr=zh_cn"},
+		{"tagged sanitize", GraphiteConfig{Tagged: true, Prefix: "servers."}, sample{Name: "monibuca_disk_used", Labels: []labelPair{{"device", ""}, {"path", "~/data dir;x"}}},
+			"servers.monibuca.disk.used;path=_/data_dir_x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newGraphiteWriter(tt.conf, nil).path(tt.s); got != tt.want {
+				t.Errorf("path = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
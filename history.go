@@ -0,0 +1,254 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"m7s.live/engine/v4/log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type HistoryConfig struct {
+	Interval  time.Duration //采样间隔
+	Retention time.Duration //保留时长
+	Metrics   string        //除看板指标外需要保留历史的指标名，支持正则表达式，为空只保留看板指标
+}
+
+func (c HistoryConfig) validate() error {
+	if err := checkPositive("interval", c.Interval); err != nil {
+		return err
+	}
+	if c.Retention < c.Interval {
+		return fmt.Errorf("retention %v is less than interval %v", c.Retention, c.Interval)
+	}
+	return nil
+}
+
+// history 定时采样并在内存中保留最近一段时间的序列，所有序列共用同一组采样时间，
+// 每个序列只保存一个定长的 float64 环形数组，缺失的点为 NaN
+type history struct {
+	mu       sync.RWMutex
+	interval time.Duration
+	times    []int64 //毫秒，和序列的值一一对应
+	head     int     //下一次写入的位置
+	size     int
+	tick     uint64 //累计采样次数
+	series   map[string]*historySeries
+	match    func(name string) bool
+}
+
+type historySeries struct {
+	name   string
+	labels []labelPair
+	values []float64
+	tick   uint64 //最近一次有值时的采样次数
+}
+
+type historyPoint struct {
+	Timestamp int64
+	Value     float64
+}
+
+// newHistoryMatcher 返回需要保留历史的指标，包括看板指标和配置的指标
+func newHistoryMatcher(conf HistoryConfig) (func(name string) bool, error) {
+	if conf.Metrics == "" {
+		return func(name string) bool { return dashboardMetrics[name] }, nil
+	}
+	re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", conf.Metrics))
+	if err != nil {
+		return nil, fmt.Errorf("invalid history metrics %q: %w", conf.Metrics, err)
+	}
+	return func(name string) bool { return dashboardMetrics[name] || re.MatchString(name) }, nil
+}
+
+func newHistory(conf HistoryConfig, match func(name string) bool) *history {
+	capacity := int(conf.Retention / conf.Interval)
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &history{
+		interval: conf.Interval,
+		times:    make([]int64, capacity),
+		series:   make(map[string]*historySeries),
+		match:    match,
+	}
+}
+
+// run 按采样间隔定时采样，直到 ctx 取消
+func (h *history) run(ctx context.Context, g prometheus.Gatherer) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			mfs, err := g.Gather()
+			if err != nil {
+				log.Warn("Exporter history gather err: ", err)
+			}
+			var selected []*dto.MetricFamily
+			for _, mf := range mfs {
+				if h.match(mf.GetName()) {
+					selected = append(selected, mf)
+				}
+			}
+			h.add(now, flatten(selected, now))
+		}
+	}
+}
+
+func (h *history) add(now time.Time, samples []sample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	pos := h.head
+	h.tick++
+	h.times[pos] = now.UnixMilli()
+	h.head = (h.head + 1) % len(h.times)
+	if h.size < len(h.times) {
+		h.size++
+	}
+	for _, s := range h.series {
+		s.values[pos] = math.NaN()
+	}
+	for _, s := range samples {
+		key := seriesKey(s.Name, s.Labels)
+		hs, ok := h.series[key]
+		if !ok {
+			hs = &historySeries{name: s.Name, labels: s.Labels, values: make([]float64, len(h.times))}
+			for i := range hs.values {
+				hs.values[i] = math.NaN()
+			}
+			h.series[key] = hs
+		}
+		hs.values[pos] = s.Value
+		hs.tick = h.tick
+	}
+	//整个保留时长内都没有值的序列(比如已经关闭的流)不再保留
+	for key, s := range h.series {
+		if h.tick-s.tick >= uint64(len(h.times)) {
+			delete(h.series, key)
+		}
+	}
+}
+
+// points 按时间顺序返回序列在 [start, end] 内的点，跳过缺失的点
+func (h *history) points(s *historySeries, start, end int64) []historyPoint {
+	var points []historyPoint
+	for i := 0; i < h.size; i++ {
+		pos := (h.head - h.size + i + len(h.times)) % len(h.times)
+		ts := h.times[pos]
+		if ts < start || ts > end || math.IsNaN(s.values[pos]) {
+			continue
+		}
+		points = append(points, historyPoint{ts, s.values[pos]})
+	}
+	return points
+}
+
+func seriesKey(name string, labels []labelPair) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, l := range labels {
+		b.WriteByte(0xff)
+		b.WriteString(l.Name)
+		b.WriteByte(0xff)
+		b.WriteString(l.Value)
+	}
+	return b.String()
+}
+
+type rangeSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]any          `json:"values"`
+}
+
+// parseTime 支持 unix 时间戳(秒，可带小数)和 RFC3339 格式
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.UnixMilli(int64(f * 1000)), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// queryRange 由 ServeHTTP 路由到 /exporter/api/query_range，返回内存历史中匹配 query 的序列，query 为 PromQL 风格的选择器，
+// start、end 为 unix 时间戳或 RFC3339 时间，默认为整个保留时长，返回格式同 Prometheus 的 query_range。
+// 不使用 API_ 方法是因为引擎会把方法名中的下划线映射为路径分隔符，无法得到 query_range 这个路径
+func (p *ExporterConfig) queryRange(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	writeError := func(err error) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{"status": "error", "error": err.Error()})
+	}
+	sel, err := parseSelector(query.Get("query"))
+	if err != nil {
+		writeError(err)
+		return
+	}
+	now := time.Now()
+	end, err := parseTime(query.Get("end"), now)
+	if err != nil {
+		writeError(fmt.Errorf("invalid end: %w", err))
+		return
+	}
+	start, err := parseTime(query.Get("start"), end.Add(-p.History.Retention))
+	if err != nil {
+		writeError(fmt.Errorf("invalid start: %w", err))
+		return
+	}
+
+	h := p.history
+	result := []rangeSeries{}
+	h.mu.RLock()
+	for _, s := range h.series {
+		if !sel.matches(s.name, s.labels) {
+			continue
+		}
+		points := h.points(s, start.UnixMilli(), end.UnixMilli())
+		if len(points) == 0 {
+			continue
+		}
+		rs := rangeSeries{Metric: map[string]string{"__name__": s.name}, Values: make([][2]any, 0, len(points))}
+		for _, l := range s.labels {
+			rs.Metric[l.Name] = l.Value
+		}
+		for _, pt := range points {
+			rs.Values = append(rs.Values, [2]any{float64(pt.Timestamp) / 1000, formatFloat(pt.Value)})
+		}
+		result = append(result, rs)
+	}
+	h.mu.RUnlock()
+	sort.Slice(result, func(i, j int) bool {
+		return seriesKeyOf(result[i].Metric) < seriesKeyOf(result[j].Metric)
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "success",
+		"data": map[string]any{
+			"resultType": "matrix",
+			"result":     result,
+		},
+	})
+}
+
+func seriesKeyOf(metric map[string]string) string {
+	labels := make([]labelPair, 0, len(metric))
+	for k, v := range metric {
+		labels = append(labels, labelPair{k, v})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return seriesKey("", labels)
+}
@@ -0,0 +1,64 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQueryRangeRoute(t *testing.T) {
+	p := newTestExporter("")
+	p.History = defaultHistory
+	p.history = newHistory(p.History, func(string) bool { return true })
+	now := time.Now()
+	for i := 2; i >= 0; i-- {
+		at := now.Add(-time.Duration(i) * p.History.Interval)
+		p.history.add(at, []sample{
+			{Name: "up", Labels: []labelPair{{"job", "a"}}, Value: float64(i), Timestamp: at.UnixMilli()},
+			{Name: "up", Labels: []labelPair{{"job", "b"}}, Value: 1, Timestamp: at.UnixMilli()},
+		})
+	}
+
+	tests := []struct {
+		path   string
+		code   int
+		series int
+	}{
+		{`/exporter/api/query_range?query=up{job="a"}`, http.StatusOK, 1},
+		{`/exporter/api/query_range?query=up`, http.StatusOK, 2},
+		{`/api/query_range?query=up`, http.StatusOK, 2},
+		{`/exporter/api/query_range?query=up{job=`, http.StatusBadRequest, 0},
+		{`/exporter/api/query/range?query=up`, http.StatusNotFound, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.code {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Status string
+				Data   struct {
+					Result []rangeSeries
+				}
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Status != "success" || len(resp.Data.Result) != tt.series {
+				t.Errorf("got status %s with %d series, want %d", resp.Status, len(resp.Data.Result), tt.series)
+			}
+			for _, s := range resp.Data.Result {
+				if len(s.Values) != 3 {
+					t.Errorf("series %v has %d points, want 3", s.Metric, len(s.Values))
+				}
+			}
+		})
+	}
+}
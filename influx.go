@@ -0,0 +1,161 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"m7s.live/engine/v4/log"
+	"m7s.live/plugin/exporter/v4/collector"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type InfluxConfig struct {
+	URL      string        //InfluxDB 地址，比如 http://influxdb:8086，为空不写入
+	Org      string        //组织
+	Bucket   string        //bucket
+	Token    string        //API token
+	Interval time.Duration //写入间隔
+	Timeout  time.Duration //请求超时
+}
+
+func (c InfluxConfig) validate() error {
+	return checkPositive("interval", c.Interval)
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// writeInfluxLines 以 InfluxDB 行协议输出样本，指标名作为 measurement，标签和 GlobalLabel 作为 tag，
+// 值写入 value 字段，时间戳精度为纳秒
+func writeInfluxLines(w io.Writer, samples []sample) {
+	var line bytes.Buffer
+	for _, s := range samples {
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+		line.Reset()
+		line.WriteString(influxMeasurementEscaper.Replace(s.Name))
+		for _, l := range withGlobalLabels(s.Labels) {
+			writeInfluxTag(&line, l.Name, l.Value)
+		}
+		line.WriteString(" value=")
+		line.WriteString(strconv.FormatFloat(s.Value, 'g', -1, 64))
+		line.WriteByte(' ')
+		line.WriteString(strconv.FormatInt(s.Timestamp*int64(time.Millisecond), 10))
+		line.WriteByte('\n')
+		w.Write(line.Bytes())
+	}
+}
+
+func writeInfluxTag(b *bytes.Buffer, k, v string) {
+	if v == "" {
+		return
+	}
+	b.WriteByte(',')
+	b.WriteString(influxTagEscaper.Replace(k))
+	b.WriteByte('=')
+	b.WriteString(influxTagEscaper.Replace(v))
+}
+
+func (p *ExporterConfig) API_influx(w http.ResponseWriter, r *http.Request) {
+	if !p.accept(w, r) {
+		return
+	}
+	g, err := p.requestGatherer(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	mfs, err := g.Gather()
+	if err != nil {
+		log.Warn("Exporter influx gather err: ", err)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writeInfluxLines(w, flatten(mfs, time.Now()))
+}
+
+type influxWriter struct {
+	conf    InfluxConfig
+	g       prometheus.Gatherer
+	client  *http.Client
+	url     string
+	results *prometheus.CounterVec
+}
+
+func newInfluxWriter(conf InfluxConfig, g prometheus.Gatherer, reg prometheus.Registerer) *influxWriter {
+	query := url.Values{}
+	query.Set("org", conf.Org)
+	query.Set("bucket", conf.Bucket)
+	query.Set("precision", "ns")
+	w := &influxWriter{
+		conf:   conf,
+		g:      g,
+		client: &http.Client{Timeout: conf.Timeout},
+		url:    strings.TrimSuffix(conf.URL, "/") + "/api/v2/write?" + query.Encode(),
+		results: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   collector.Namespace,
+			Subsystem:   "exporter",
+			Name:        "influx_write_total",
+			Help:        "写入 InfluxDB 的次数，result 为 success 或 failure",
+			ConstLabels: collector.GlobalLabel,
+		}, []string{"result"}),
+	}
+	reg.MustRegister(w.results)
+	return w
+}
+
+// run 按写入间隔定时写入，直到 ctx 取消
+func (w *influxWriter) run(ctx context.Context) {
+	ticker := time.NewTicker(w.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := w.write(ctx, now); err != nil {
+				w.results.WithLabelValues("failure").Inc()
+				log.Warn("Exporter write to influxdb err: ", err)
+			} else {
+				w.results.WithLabelValues("success").Inc()
+			}
+		}
+	}
+}
+
+func (w *influxWriter) write(ctx context.Context, now time.Time) error {
+	mfs, err := w.g.Gather()
+	if err != nil {
+		log.Warn("Exporter influx gather err: ", err)
+	}
+	var body bytes.Buffer
+	writeInfluxLines(&body, flatten(mfs, now))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.conf.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.conf.Token)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
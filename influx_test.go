@@ -0,0 +1,79 @@
+package exporter
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"m7s.live/plugin/exporter/v4/collector"
+)
+
+func TestWriteInfluxLines(t *testing.T) {
+	global := collector.GlobalLabel
+	collector.GlobalLabel = prometheus.Labels{"hostname": "host 1"}
+	defer func() { collector.GlobalLabel = global }()
+
+	name := func(s string) *string { return &s }
+	label := func(name, value string) *dto.LabelPair { return &dto.LabelPair{Name: &name, Value: &value} }
+	f := func(v float64) *float64 { return &v }
+	u := func(v uint64) *uint64 { return &v }
+	i := func(v int64) *int64 { return &v }
+	counter, histogram, summary := dto.MetricType_COUNTER, dto.MetricType_HISTOGRAM, dto.MetricType_SUMMARY
+	mfs := []*dto.MetricFamily{
+		{Name: name("bytes_total"), Type: &counter, Metric: []*dto.Metric{
+			{Label: []*dto.LabelPair{label("type", "rtmp")}, Counter: &dto.Counter{Value: f(1e21)}},
+		}},
+		//没有 +Inf 桶时补上
+		{Name: name("latency_seconds"), Type: &histogram, Metric: []*dto.Metric{
+			{Label: []*dto.LabelPair{label("type", "rtmp")}, Histogram: &dto.Histogram{SampleCount: u(6), SampleSum: f(2.5), Bucket: []*dto.Bucket{
+				{UpperBound: f(0.1), CumulativeCount: u(1)},
+				{UpperBound: f(1), CumulativeCount: u(4)},
+			}}},
+		}},
+		//自带时间戳，NaN 的分位数不输出
+		{Name: name("gc_seconds"), Type: &summary, Metric: []*dto.Metric{
+			{TimestampMs: i(2000), Summary: &dto.Summary{SampleCount: u(2), SampleSum: f(1), Quantile: []*dto.Quantile{
+				{Quantile: f(0.5), Value: f(0.4)},
+				{Quantile: f(0.99), Value: f(math.NaN())},
+			}}},
+		}},
+	}
+	samples := []sample{
+		//measurement 中转义空格和逗号，等号不需要转义；tag 的键和值中转义空格、逗号和等号；空值的 tag 不输出
+		{Name: "cpu load,avg=x", Labels: []labelPair{{"core id", "0"}, {"empty", ""}, {"path", `C:\a b,c=d`}}, Value: 1.5, Timestamp: 1000},
+		{Name: "nan", Value: math.NaN(), Timestamp: 1000},
+		{Name: "inf", Value: math.Inf(1), Timestamp: 1000},
+		//样本中已有的 GlobalLabel 不重复输出
+		{Name: "up", Labels: []labelPair{{"hostname", "other"}}, Value: 1, Timestamp: 1000},
+	}
+	samples = append(samples, flatten(mfs, time.Unix(1, 0))...)
+
+	want := []string{
+		`cpu\ load\,avg=x,core\ id=0,hostname=host\ 1,path=C:\a\ b\,c\=d value=1.5 1000000000`,
+		`up,hostname=other value=1 1000000000`,
+		`bytes_total,hostname=host\ 1,type=rtmp value=1e+21 1000000000`,
+		`latency_seconds_bucket,hostname=host\ 1,le=0.1,type=rtmp value=1 1000000000`,
+		`latency_seconds_bucket,hostname=host\ 1,le=1,type=rtmp value=4 1000000000`,
+		`latency_seconds_bucket,hostname=host\ 1,le=+Inf,type=rtmp value=6 1000000000`,
+		`latency_seconds_sum,hostname=host\ 1,type=rtmp value=2.5 1000000000`,
+		`latency_seconds_count,hostname=host\ 1,type=rtmp value=6 1000000000`,
+		`gc_seconds,hostname=host\ 1,quantile=0.5 value=0.4 2000000000`,
+		`gc_seconds_sum,hostname=host\ 1 value=1 2000000000`,
+		`gc_seconds_count,hostname=host\ 1 value=2 2000000000`,
+	}
+	var buf bytes.Buffer
+	writeInfluxLines(&buf, samples)
+	got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(got), len(want), buf.String())
+	}
+	for n := range want {
+		if got[n] != want[n] {
+			t.Errorf("line %d:\n got %s\nwant %s", n, got[n], want[n])
+		}
+	}
+}
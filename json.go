@@ -0,0 +1,104 @@
+package exporter
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"m7s.live/engine/v4/log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type jsonSample struct {
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels"`
+	Value     float64           `json:"value"`
+	Timestamp int64             `json:"timestamp"`
+}
+
+type jsonFamily struct {
+	Name    string       `json:"name"`
+	Help    string       `json:"help"`
+	Type    string       `json:"type"`
+	Samples []jsonSample `json:"samples"`
+}
+
+type jsonCollector struct {
+	Name    string       `json:"name"`
+	Metrics []jsonFamily `json:"metrics"`
+}
+
+// API_json 以 JSON 返回每个采集器的指标，可用 collector 参数过滤采集器(可多个)，prefix 参数过滤指标名前缀
+func (p *ExporterConfig) API_json(w http.ResponseWriter, r *http.Request) {
+	if !p.accept(w, r) {
+		return
+	}
+	query := r.URL.Query()
+	names := query["collector"]
+	collectors := p.enabledCollectors()
+	if len(names) == 0 {
+		for name := range collectors {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	prefix := query.Get("prefix")
+	now := time.Now()
+	result := make([]jsonCollector, 0, len(names))
+	for _, name := range names {
+		c, ok := collectors[name]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("collector " + name + " is not enabled"))
+			return
+		}
+		reg := prometheus.NewPedanticRegistry()
+		if err := reg.Register(c); err != nil {
+			log.Warnf("Exporter json register collector %s err: %s", name, err)
+			continue
+		}
+		mfs, err := reg.Gather()
+		if err != nil {
+			log.Warnf("Exporter json gather collector %s err: %s", name, err)
+		}
+		jc := jsonCollector{Name: name, Metrics: []jsonFamily{}}
+		for _, mf := range mfs {
+			if !strings.HasPrefix(mf.GetName(), prefix) {
+				continue
+			}
+			jc.Metrics = append(jc.Metrics, newJsonFamily(mf, now))
+		}
+		result = append(result, jc)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"collectors": result})
+}
+
+func newJsonFamily(mf *dto.MetricFamily, now time.Time) jsonFamily {
+	jf := jsonFamily{
+		Name:    mf.GetName(),
+		Help:    mf.GetHelp(),
+		Type:    strings.ToLower(mf.GetType().String()),
+		Samples: []jsonSample{},
+	}
+	for _, s := range flatten([]*dto.MetricFamily{mf}, now) {
+		//JSON 无法表示 NaN 和 Inf
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+		labels := make(map[string]string, len(s.Labels))
+		for _, l := range s.Labels {
+			labels[l.Name] = l.Value
+		}
+		jf.Samples = append(jf.Samples, jsonSample{
+			Name:      s.Name,
+			Labels:    labels,
+			Value:     s.Value,
+			Timestamp: s.Timestamp,
+		})
+	}
+	return jf
+}
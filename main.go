@@ -1,6 +1,8 @@
 package exporter
 
 import (
+	"context"
+	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	. "m7s.live/engine/v4"
@@ -11,6 +13,8 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -18,37 +22,6 @@ const (
 	defaultCollectorsPlaceholder = "[defaults]" //如果是 defaults，在 yaml 里要用双引号
 )
 
-func loadCollectors(list string, cfg config.Config) map[string]collector.Collector {
-	collectors := map[string]collector.Collector{}
-	enabled := expandEnabledCollectors(list)
-
-	var (
-		cCfg config.Config
-		ok   bool
-	)
-	for _, name := range enabled {
-
-		if cfg.Has(name) {
-			collectorCfg := cfg.Get(name)
-			cCfg, ok = collectorCfg.(config.Config)
-			if !ok {
-				log.Warnf("Exporter loadCollector %s config err, config is not map", name)
-				continue
-			}
-		} else {
-			cCfg = nil
-		}
-		c, err := collector.Build(name, cCfg)
-		if err != nil {
-			log.Warnf("Exporter loadCollector %s err: %s", name, err)
-			continue
-		}
-		collectors[name] = c
-	}
-
-	return collectors
-}
-
 func initExporter(p *ExporterConfig) prometheus.Gatherer {
 	if p.PrintCollectors {
 		collectors := collector.Available()
@@ -64,21 +37,23 @@ func initExporter(p *ExporterConfig) prometheus.Gatherer {
 
 	}
 
-	collectors := loadCollectors(p.Enabled, p.CollectorConfig)
 	reg := prometheus.NewPedanticRegistry()
 
-	for name, c := range collectors {
-		reg.MustRegister(c)
-		p.collectors[name] = c
+	if err := p.Auth.init(); err != nil {
+		log.Error("Exporter auth config err, all requests will be rejected: ", err)
 	}
+	p.rejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   collector.Namespace,
+		Subsystem:   "exporter",
+		Name:        "rejected_requests_total",
+		Help:        "被拒绝的请求总数，reason 为 forbidden 或 unauthorized",
+		ConstLabels: collector.GlobalLabel,
+	}, []string{"reason"})
+	reg.MustRegister(p.rejected)
+	p.registry = reg
+	p.reload()
 
-	gatherers := prometheus.Gatherers{
-		prometheus.DefaultGatherer,
-		reg,
-	}
-
-	return gatherers
-
+	return &p.swap
 }
 
 func expandEnabledCollectors(enabled string) []string {
@@ -109,9 +84,36 @@ type ExporterConfig struct {
 	NodeAddr        string //节点位置
 	Enabled         string //开启的采集器
 	PrintCollectors bool
-	CollectorConfig config.Config //采集器的配置
+	CollectorConfig config.Config     //采集器的配置
+	Timeout         time.Duration     //采集器的默认超时，超时后返回已采集的部分结果，单个采集器可以在自己的配置中用 timeout 覆盖
+	Auth            AuthConfig        //接口的访问控制
+	Push            PushConfig        //推送到 Pushgateway
+	RemoteWrite     RemoteWriteConfig //通过 remote_write 协议发送
+	Influx          InfluxConfig      //写入 InfluxDB v2
+	Statsd          StatsdConfig      //发送到 StatsD / DogStatsD
+	Otlp            OtlpConfig        //通过 OTLP/HTTP 发送到 OpenTelemetry collector
+	Graphite        GraphiteConfig    //通过 plaintext 协议发送到 Graphite
+	History         HistoryConfig     //内存中保留的历史，用于内置看板和 query_range 接口
+	Alert           AlertConfig       //本地告警规则
 	h               http.Handler
+	gatherer        prometheus.Gatherer
+	history         *history
+	alerter         *alerter
+	hostname        string
+	registry        *prometheus.Registry
+	rejected        *prometheus.CounterVec
+	swap            swapGatherer
+	mu              sync.RWMutex //保护以下采集器相关的字段，热加载时会整体替换
 	collectors      map[string]collector.Collector
+	fingerprints    map[string]string //构建采集器时使用的配置，用于判断配置是否变化
+	cancels         map[string]context.CancelFunc
+	buildErrors     map[string]error //最近一次加载时构建失败的采集器
+}
+
+// defaultHistory 历史的默认配置，配置有误时使用
+var defaultHistory = HistoryConfig{
+	Interval:  5 * time.Second,
+	Retention: time.Hour,
 }
 
 var exporter = ExporterConfig{
@@ -119,18 +121,56 @@ var exporter = ExporterConfig{
 	Enabled:         "[defaults]",
 	PrintCollectors: true,
 	CollectorConfig: config.Config{},
-	collectors:      make(map[string]collector.Collector),
+	Timeout:         5 * time.Second,
+	Push: PushConfig{
+		Job:      "monibuca",
+		Interval: 15 * time.Second,
+		Retry:    3,
+	},
+	RemoteWrite: RemoteWriteConfig{
+		Interval:   15 * time.Second,
+		Shards:     2,
+		QueueSize:  10000,
+		BatchSize:  500,
+		Timeout:    30 * time.Second,
+		MaxBackoff: 30 * time.Second,
+	},
+	Influx: InfluxConfig{
+		Interval: 15 * time.Second,
+		Timeout:  10 * time.Second,
+	},
+	Statsd: StatsdConfig{
+		Interval:      10 * time.Second,
+		DogStatsd:     true,
+		MaxPacketSize: 1432,
+	},
+	Otlp: OtlpConfig{
+		Encoding: "protobuf",
+		Interval: 15 * time.Second,
+		Timeout:  10 * time.Second,
+	},
+	Graphite: GraphiteConfig{
+		Interval: 15 * time.Second,
+		Timeout:  10 * time.Second,
+	},
+	History: defaultHistory,
+	Alert: AlertConfig{
+		Interval: 15 * time.Second,
+	},
+	collectors: make(map[string]collector.Collector),
 }
 
 func (p *ExporterConfig) OnEvent(event any) {
 	switch event.(type) {
 	case FirstConfig:
 		cfg := config.Config(event.(FirstConfig))
-		p.CollectorConfig = cfg.GetChild("collector")
+		p.applyConfig(cfg)
+		p.Alert.Rules = cfg.GetChild("alert").GetChild("rules")
 		hostname, err := os.Hostname()
 		if err != nil {
 			log.Error("Exporter get hostname err ", err)
 		}
+		p.hostname = hostname
 		collector.GlobalLabel = prometheus.Labels{
 			"nodeaddr": p.NodeAddr,
 			"hostname": hostname,
@@ -139,28 +179,166 @@ func (p *ExporterConfig) OnEvent(event any) {
 		}
 		g := initExporter(p)
 
-		p.h = promhttp.HandlerFor(g,
-			promhttp.HandlerOpts{
-				ErrorLog:      errLogger{},
-				ErrorHandling: promhttp.ContinueOnError,
-			})
+		p.gatherer = g
+		if err := p.History.validate(); err != nil {
+			log.Error("Exporter history config err, use the default interval and retention: ", err)
+			p.History.Interval, p.History.Retention = defaultHistory.Interval, defaultHistory.Retention
+		}
+		match, err := newHistoryMatcher(p.History)
+		if err != nil {
+			log.Error("Exporter history config err: ", err)
+			match, _ = newHistoryMatcher(HistoryConfig{})
+		}
+		p.history = newHistory(p.History, match)
+		go p.history.run(plugin, g)
+		p.h = newHandler(g, p.createdTimes)
+		if len(p.Alert.Rules) > 0 {
+			if p.alerter, err = newAlerter(p.Alert, g); err != nil {
+				log.Error("Exporter alert config err: ", err)
+			} else {
+				p.registry.MustRegister(p.alerter)
+				go p.alerter.run(plugin)
+			}
+		}
+		if p.Push.URL != "" && checkConfig("push", p.Push.validate()) {
+			instance := p.hostname + "@" + p.NodeAddr
+			go newPusher(p.Push, instance, g, p.registry).run(plugin)
+		}
+		if p.RemoteWrite.URL != "" && checkConfig("remote_write", p.RemoteWrite.validate()) {
+			go newRemoteWriter(p.RemoteWrite, g, p.registry).run(plugin)
+		}
+		if p.Influx.URL != "" && checkConfig("influx", p.Influx.validate()) {
+			go newInfluxWriter(p.Influx, g, p.registry).run(plugin)
+		}
+		if p.Statsd.Addr != "" && checkConfig("statsd", p.Statsd.validate()) {
+			go newStatsdEmitter(p.Statsd, g).run(plugin)
+		}
+		if p.Otlp.URL != "" && checkConfig("otlp", p.Otlp.validate()) {
+			resource := map[string]string{
+				"service.name":      "monibuca",
+				"service.version":   SysInfo.Version,
+				"host.name":         p.hostname,
+				"monibuca.nodeaddr": p.NodeAddr,
+			}
+			for k, v := range collector.GlobalLabel {
+				resource[k] = v
+			}
+			go newOtlpExporter(p.Otlp, resource, g, p.registry).run(plugin)
+		}
+		if p.Graphite.Addr != "" && checkConfig("graphite", p.Graphite.validate()) {
+			go newGraphiteWriter(p.Graphite, g).run(plugin)
+		}
 		p._onevent(event)
+	case config.Config:
+		// 配置变更，重新加载受影响的采集器
+		p.applyConfig(event.(config.Config))
+		if p.h != nil {
+			p.reload()
+		}
 	default:
 		p._onevent(event)
 	}
 }
 
-func (p *ExporterConfig) API_metrics(w http.ResponseWriter, r *http.Request) {
+// checkConfig 配置有误时记录错误并返回 false，对应的功能不会启动
+func checkConfig(name string, err error) bool {
+	if err != nil {
+		log.Error("Exporter "+name+" config err: ", err)
+		return false
+	}
+	return true
+}
+
+// checkPositive 检查时长配置大于 0，time.NewTicker 遇到非正数会 panic
+func checkPositive(name string, d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("%s must be positive, got %v", name, d)
+	}
+	return nil
+}
+
+// accept 检查插件是否已经初始化以及请求是否允许访问，不通过时写入响应并返回 false
+func (p *ExporterConfig) accept(w http.ResponseWriter, r *http.Request) bool {
 	if p.h == nil {
 		w.WriteHeader(500)
 		w.Write([]byte("exporter is not init,wait"))
+		return false
+	}
+	return p.authorize(w, r)
+}
+
+func (p *ExporterConfig) API_metrics(w http.ResponseWriter, r *http.Request) {
+	if !p.accept(w, r) {
 		return
 	}
-	p.h.ServeHTTP(w, r)
+	g, err := p.requestGatherer(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if g == p.gatherer {
+		p.h.ServeHTTP(w, r)
+		return
+	}
+	newHandler(g, p.createdTimes).ServeHTTP(w, r)
+}
+
+// requestGatherer 返回请求对应的 Gatherer，带 collect[] 或 exclude[] 参数时只采集部分采集器
+func (p *ExporterConfig) requestGatherer(r *http.Request) (prometheus.Gatherer, error) {
+	query := r.URL.Query()
+	collect, exclude := query["collect[]"], query["exclude[]"]
+	if len(collect) == 0 && len(exclude) == 0 {
+		return p.gatherer, nil
+	}
+	return p.filteredGatherer(collect, exclude)
+}
+
+// filteredGatherer 按 collect[] 和 exclude[] 参数只采集部分采集器，用法同 node_exporter，
+// 过滤时不包含 prometheus.DefaultGatherer 中的指标
+func (p *ExporterConfig) filteredGatherer(collect, exclude []string) (prometheus.Gatherer, error) {
+	collectors := p.enabledCollectors()
+	for _, names := range [][]string{collect, exclude} {
+		for _, name := range names {
+			if _, ok := collectors[name]; !ok {
+				return nil, fmt.Errorf("collector %q is not enabled", name)
+			}
+		}
+	}
+	selected := map[string]bool{}
+	if len(collect) == 0 {
+		for name := range collectors {
+			selected[name] = true
+		}
+	}
+	for _, name := range collect {
+		selected[name] = true
+	}
+	for _, name := range exclude {
+		delete(selected, name)
+	}
+	reg := prometheus.NewPedanticRegistry()
+	for name := range selected {
+		if err := reg.Register(collectors[name]); err != nil {
+			return nil, fmt.Errorf("register collector %q err: %w", name, err)
+		}
+	}
+	return reg, nil
+}
+
+// newHandler 返回指标接口的处理器，created 见 openMetricsHandler
+func newHandler(g prometheus.Gatherer, created func() map[string]time.Time) http.Handler {
+	h := promhttp.HandlerFor(g,
+		promhttp.HandlerOpts{
+			ErrorLog:          errLogger{},
+			ErrorHandling:     promhttp.ContinueOnError,
+			EnableOpenMetrics: true,
+		})
+	return &openMetricsHandler{Handler: h, g: g, created: created}
 }
 
 func (p *ExporterConfig) _onevent(event any) {
-	for _, c := range p.collectors {
+	for _, c := range p.enabledCollectors() {
 		c.OnEvent(event)
 	}
 }
@@ -0,0 +1,67 @@
+package exporter
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestAPIMetricsFilter(t *testing.T) {
+	p := newTestExporter("test_reload_a,test_reload_b")
+	tests := []struct {
+		name     string
+		query    string
+		code     int
+		want     []string //输出的指标中属于测试采集器的部分
+		defaults bool     //是否包含 prometheus.DefaultGatherer 中的指标
+	}{
+		{"no filter", "", http.StatusOK, []string{"test_reload_a", "test_reload_b"}, true},
+		{"collect", "collect[]=test_reload_a", http.StatusOK, []string{"test_reload_a"}, false},
+		{"collect all", "collect[]=test_reload_a&collect[]=test_reload_b", http.StatusOK, []string{"test_reload_a", "test_reload_b"}, false},
+		{"exclude only", "exclude[]=test_reload_a", http.StatusOK, []string{"test_reload_b"}, false},
+		{"collect and exclude", "collect[]=test_reload_a&collect[]=test_reload_b&exclude[]=test_reload_b", http.StatusOK, []string{"test_reload_a"}, false},
+		{"exclude all", "exclude[]=test_reload_a&exclude[]=test_reload_b", http.StatusOK, nil, false},
+		{"unknown collect", "collect[]=not_exist", http.StatusBadRequest, nil, false},
+		{"unknown exclude", "exclude[]=not_exist", http.StatusBadRequest, nil, false},
+		{"registered but not enabled", "collect[]=base", http.StatusBadRequest, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			p.API_metrics(rec, httptest.NewRequest(http.MethodGet, "/exporter/api/metrics?"+tt.query, nil))
+			if rec.Code != tt.code {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var got []string
+			defaults := false
+			scanner := bufio.NewScanner(rec.Body)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if strings.HasPrefix(line, "#") {
+					continue
+				}
+				name := strings.FieldsFunc(line, func(r rune) bool { return r == '{' || r == ' ' })[0]
+				if strings.HasPrefix(name, "test_reload_") {
+					got = append(got, name)
+				}
+				if strings.HasPrefix(name, "go_") {
+					defaults = true
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got metrics %v, want %v", got, tt.want)
+			}
+			if defaults != tt.defaults {
+				t.Errorf("default gatherer metrics included = %v, want %v", defaults, tt.defaults)
+			}
+		})
+	}
+}
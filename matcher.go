@@ -0,0 +1,106 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type labelMatcher struct {
+	name  string
+	op    string //=、!=、=~、!~
+	value string
+	re    *regexp.Regexp
+}
+
+func (m *labelMatcher) matches(v string) bool {
+	switch m.op {
+	case "=":
+		return v == m.value
+	case "!=":
+		return v != m.value
+	case "=~":
+		return m.re.MatchString(v)
+	default:
+		return !m.re.MatchString(v)
+	}
+}
+
+// selector 指标选择器，语法同 PromQL，比如 monibuca_media_stream_bps{name=~"live/.*",hostname!="a"}
+type selector struct {
+	name     string
+	matchers []*labelMatcher
+}
+
+func (s *selector) matches(name string, labels []labelPair) bool {
+	if name != s.name {
+		return false
+	}
+	for _, m := range s.matchers {
+		v := ""
+		for _, l := range labels {
+			if l.Name == m.name {
+				v = l.Value
+				break
+			}
+		}
+		if !m.matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseSelector(input string) (*selector, error) {
+	input = strings.TrimSpace(input)
+	brace := strings.IndexByte(input, '{')
+	if brace < 0 {
+		if input == "" {
+			return nil, fmt.Errorf("empty selector")
+		}
+		return &selector{name: input}, nil
+	}
+	s := &selector{name: strings.TrimSpace(input[:brace])}
+	if s.name == "" {
+		return nil, fmt.Errorf("selector %q has no metric name", input)
+	}
+	rest := strings.TrimSpace(input[brace+1:])
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		if strings.HasPrefix(rest, "}") {
+			if strings.TrimSpace(rest[1:]) != "" {
+				return nil, fmt.Errorf("unexpected %q after selector", rest[1:])
+			}
+			return s, nil
+		}
+		i := strings.IndexAny(rest, "=!")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid matcher in selector %q", input)
+		}
+		m := &labelMatcher{name: strings.TrimSpace(rest[:i])}
+		rest = rest[i:]
+		for _, op := range []string{"=~", "!~", "!=", "="} {
+			if strings.HasPrefix(rest, op) {
+				m.op = op
+				break
+			}
+		}
+		if m.op == "" {
+			return nil, fmt.Errorf("invalid operator in selector %q", input)
+		}
+		rest = strings.TrimSpace(rest[len(m.op):])
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of label %s in selector %q", m.name, input)
+		}
+		m.value, _ = strconv.Unquote(quoted)
+		rest = rest[len(quoted):]
+		if m.op == "=~" || m.op == "!~" {
+			if m.re, err = regexp.Compile("^(?:" + m.value + ")$"); err != nil {
+				return nil, fmt.Errorf("invalid regexp of label %s: %w", m.name, err)
+			}
+		}
+		s.matchers = append(s.matchers, m)
+	}
+}
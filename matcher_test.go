@@ -0,0 +1,88 @@
+package exporter
+
+import (
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		input    string
+		name     string
+		matchers []string //每个匹配器的 name、op、value 拼接
+		wantErr  bool
+	}{
+		{input: "up", name: "up"},
+		{input: "  up  ", name: "up"},
+		{input: "up{}", name: "up"},
+		{input: `up{job="a"}`, name: "up", matchers: []string{"job=a"}},
+		{input: `up{ job = "a" , name!="b", }`, name: "up", matchers: []string{"job=a", "name!=b"}},
+		{input: `up{name=~"live/.*",type!~"rtmp|rtsp"}`, name: "up", matchers: []string{"name=~live/.*", "type!~rtmp|rtsp"}},
+		{input: `up{name="a\"b"}`, name: "up", matchers: []string{`name=a"b`}},
+		{input: "", wantErr: true},
+		{input: `{job="a"}`, wantErr: true},
+		{input: `up{job}`, wantErr: true},
+		{input: `up{job="a"`, wantErr: true},
+		{input: `up{job=a}`, wantErr: true},
+		{input: `up{job<"a"}`, wantErr: true},
+		{input: `up{job="a"} extra`, wantErr: true},
+		{input: `up{job=~"("}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			s, err := parseSelector(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseSelector(%q) = %+v, want error", tt.input, s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSelector(%q) err: %v", tt.input, err)
+			}
+			if s.name != tt.name {
+				t.Errorf("name = %q, want %q", s.name, tt.name)
+			}
+			if len(s.matchers) != len(tt.matchers) {
+				t.Fatalf("got %d matchers, want %d", len(s.matchers), len(tt.matchers))
+			}
+			for i, m := range s.matchers {
+				if got := m.name + m.op + m.value; got != tt.matchers[i] {
+					t.Errorf("matcher %d = %q, want %q", i, got, tt.matchers[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	labels := []labelPair{{"name", "live/test"}, {"type", "rtmp"}}
+	tests := []struct {
+		selector string
+		name     string
+		want     bool
+	}{
+		{"up", "up", true},
+		{"up", "down", false},
+		{`up{name="live/test"}`, "up", true},
+		{`up{name="live/other"}`, "up", false},
+		{`up{name!="live/other"}`, "up", true},
+		{`up{name=~"live/.*"}`, "up", true},
+		{`up{name=~"live"}`, "up", false}, //正则需要完整匹配
+		{`up{type!~"rtsp|hls"}`, "up", true},
+		{`up{type!~"rtmp|hls"}`, "up", false},
+		{`up{missing=""}`, "up", true}, //不存在的标签按空值匹配
+		{`up{missing!=""}`, "up", false},
+		{`up{name=~"live/.*",type="rtsp"}`, "up", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			s, err := parseSelector(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.matches(tt.name, labels); got != tt.want {
+				t.Errorf("matches(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
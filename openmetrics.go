@@ -0,0 +1,114 @@
+package exporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openMetricsUnits 按 OpenMetrics 的约定，指标名以单位结尾时输出 # UNIT
+var openMetricsUnits = []string{"seconds", "bytes", "percent", "ratio", "celsius", "meters", "volts", "amperes", "joules", "grams"}
+
+var openMetricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// openMetricsHandler 协商到 OpenMetrics 时自行编码，在 expfmt 的基础上补充 # UNIT 和计数器的 _created，
+// 其它格式交给 promhttp 处理
+type openMetricsHandler struct {
+	http.Handler
+	g prometheus.Gatherer
+	//返回知道起点的计数器名称到起点的映射，只有其中的计数器输出 _created，为 nil 时都不输出
+	created func() map[string]time.Time
+}
+
+func (h *openMetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if expfmt.NegotiateIncludingOpenMetrics(r.Header) != expfmt.FmtOpenMetrics {
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+	var created map[string]time.Time
+	if h.created != nil {
+		created = h.created()
+	}
+	mfs, err := h.g.Gather()
+	if err != nil {
+		errLogger{}.Println("error gathering metrics:", err)
+		if len(mfs) == 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", string(expfmt.FmtOpenMetrics))
+	var out io.Writer = w
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+	for _, mf := range mfs {
+		if err := writeOpenMetricsFamily(out, mf, created); err != nil {
+			errLogger{}.Println("error encoding metric family:", err)
+			return
+		}
+	}
+	expfmt.FinalizeOpenMetrics(out)
+}
+
+// writeOpenMetricsFamily 输出一个指标族，计数器在 created 中时补上 _created
+func writeOpenMetricsFamily(out io.Writer, mf *dto.MetricFamily, created map[string]time.Time) error {
+	var buf bytes.Buffer
+	if _, err := expfmt.MetricFamilyToOpenMetrics(&buf, mf); err != nil {
+		return err
+	}
+	name := mf.GetName()
+	isCounter := mf.GetType() == dto.MetricType_COUNTER && strings.HasSuffix(name, "_total")
+	shortName := name
+	if isCounter {
+		shortName = strings.TrimSuffix(name, "_total")
+	}
+	start, hasCreated := created[name]
+	hasCreated = hasCreated && isCounter
+	createdValue := strconv.FormatFloat(float64(start.UnixNano())/1e9, 'f', -1, 64)
+	metrics := mf.GetMetric()
+	var result bytes.Buffer
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		result.WriteString(line)
+		if strings.HasPrefix(line, "# TYPE ") {
+			for _, unit := range openMetricsUnits {
+				if strings.HasSuffix(shortName, "_"+unit) {
+					result.WriteString("# UNIT " + shortName + " " + unit + "\n")
+					break
+				}
+			}
+			continue
+		}
+		//计数器每个指标正好对应一行样本，按顺序补上 _created
+		if hasCreated && !strings.HasPrefix(line, "#") && len(metrics) > 0 {
+			result.WriteString(shortName + "_created" + openMetricsLabels(metrics[0].GetLabel()) + " " + createdValue + "\n")
+			metrics = metrics[1:]
+		}
+	}
+	_, err := out.Write(result.Bytes())
+	return err
+}
+
+func openMetricsLabels(labels []*dto.LabelPair) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		pairs = append(pairs, l.GetName()+`="`+openMetricsLabelEscaper.Replace(l.GetValue())+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
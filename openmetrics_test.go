@@ -0,0 +1,121 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"m7s.live/engine/v4"
+	"m7s.live/plugin/exporter/v4/collector"
+)
+
+// openMetricsBody 以 OpenMetrics 格式请求指标接口
+func openMetricsBody(h http.Handler) string {
+	req := httptest.NewRequest(http.MethodGet, "/exporter/api/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Body.String()
+}
+
+func TestOpenMetricsCreatedAndUnit(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	streams := prometheus.NewCounter(prometheus.CounterOpts{Name: "monibuca_media_streams_total", Help: "streams"})
+	streams.Add(3)
+	netBytes := prometheus.NewCounter(prometheus.CounterOpts{Name: "monibuca_net_bytes_total", Help: "os counter"})
+	lifetime := prometheus.NewGauge(prometheus.GaugeOpts{Name: "monibuca_stream_age_seconds", Help: "age"})
+	legacy := prometheus.NewCounter(prometheus.CounterOpts{Name: "monibuca_media_total_stream_sum", Help: "legacy"})
+	reg.MustRegister(streams, netBytes, lifetime, legacy)
+	created := func() map[string]time.Time {
+		return map[string]time.Time{
+			"monibuca_media_streams_total":    time.Unix(1600000000, 0),
+			"monibuca_stream_age_seconds":     time.Unix(1600000000, 0),
+			"monibuca_media_total_stream_sum": time.Unix(1600000000, 0),
+		}
+	}
+	body := openMetricsBody(newHandler(reg, created))
+
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"# TYPE monibuca_media_streams counter\n", true},
+		{"monibuca_media_streams_total 3.0\n", true},
+		{"monibuca_media_streams_created 1600000000\n", true},
+		//不知道起点的计数器不输出 _created
+		{"monibuca_net_bytes_total 0.0\n", true},
+		{"monibuca_net_bytes_created", false},
+		{"# UNIT monibuca_stream_age_seconds seconds\n", true},
+		{"monibuca_stream_age_seconds_created", false},
+		{"# TYPE monibuca_media_total_stream_sum unknown\n", true},
+		{"monibuca_media_total_stream_sum_created", false},
+		{"# EOF\n", true},
+	}
+	for _, tt := range tests {
+		if got := strings.Contains(body, tt.line); got != tt.want {
+			t.Errorf("contains %q = %v, want %v\n%s", tt.line, got, tt.want, body)
+		}
+	}
+}
+
+func TestOpenMetricsMediaExemplars(t *testing.T) {
+	c, err := collector.Build("media", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	stream := &engine.Stream{Path: "live/test", Publisher: &engine.IO{Type: "rtmp", RemoteAddr: "10.0.0.1:1935"}}
+	c.OnEvent(engine.SEpublish{StateEvent: engine.StateEvent{StreamEvent: engine.StreamEvent{Event: engine.CreateEvent(stream)}}})
+
+	req := httptest.NewRequest(http.MethodGet, "/exporter/api/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+	rec := httptest.NewRecorder()
+	newHandler(reg, nil).ServeHTTP(rec, req)
+	body := rec.Body.String()
+
+	for _, prefix := range []string{
+		`monibuca_media_streams_total 1.0 # {stream="live/test"} 1.0 `,
+		`monibuca_media_publishers_total{addr_class="private",type="rtmp"} 1.0 # {stream="live/test"} 1.0 `,
+		`monibuca_media_stream_events_total{event="publish"} 1.0 # {stream="live/test"} 1.0 `,
+	} {
+		if !strings.Contains(body, prefix) {
+			t.Errorf("missing %q in\n%s", prefix, body)
+		}
+	}
+}
+
+// TestOpenMetricsCreatedFromBuild media 的计数器以采集器的构建时间作为 _created，插件自身的计数器不输出
+func TestOpenMetricsCreatedFromBuild(t *testing.T) {
+	before := time.Now()
+	p := newTestExporter("media")
+	after := time.Now()
+	p.rejected.WithLabelValues("forbidden").Inc()
+	body := openMetricsBody(p.h)
+
+	var created float64
+	for _, line := range strings.Split(body, "\n") {
+		if v := strings.TrimPrefix(line, "monibuca_media_streams_created "); v != line {
+			var err error
+			if created, err = strconv.ParseFloat(v, 64); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	//浮点数的秒只精确到微秒左右
+	if created < float64(before.UnixMicro()-1)/1e6 || created > float64(after.UnixMicro()+1)/1e6 {
+		t.Errorf("monibuca_media_streams_created = %v, want between %v and %v\n%s", created, before, after, body)
+	}
+	for _, name := range []string{"monibuca_exporter_rejected_requests_created", "monibuca_media_track_dropped_frames_created"} {
+		if strings.Contains(body, name) {
+			t.Errorf("unexpected %s in\n%s", name, body)
+		}
+	}
+	if !strings.Contains(body, "monibuca_exporter_rejected_requests_total") {
+		t.Errorf("missing rejected_requests_total in\n%s", body)
+	}
+}
@@ -0,0 +1,456 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"m7s.live/engine/v4"
+	"m7s.live/engine/v4/log"
+	"m7s.live/plugin/exporter/v4/collector"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type OtlpConfig struct {
+	URL      string        //OTLP/HTTP 的 metrics 地址，比如 http://otel-collector:4318/v1/metrics，为空不发送
+	Encoding string        //编码格式，protobuf 或 json
+	Interval time.Duration //发送间隔
+	Timeout  time.Duration //请求超时
+}
+
+func (c OtlpConfig) validate() error {
+	return checkPositive("interval", c.Interval)
+}
+
+// 以下结构对应 opentelemetry-proto 中 metrics/v1 的消息，json 标签即 OTLP/JSON 的字段名，
+// protobuf 编码见各自的 appendProto 方法
+
+const otlpCumulative = 2 //AggregationTemporality CUMULATIVE
+
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes"`
+	StartTimeUnixNano uint64         `json:"startTimeUnixNano,string,omitempty"`
+	TimeUnixNano      uint64         `json:"timeUnixNano,string"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes"`
+	StartTimeUnixNano uint64         `json:"startTimeUnixNano,string"`
+	TimeUnixNano      uint64         `json:"timeUnixNano,string"`
+	Count             uint64         `json:"count,string"`
+	Sum               float64        `json:"sum"`
+	BucketCounts      []string       `json:"bucketCounts"`
+	ExplicitBounds    []float64      `json:"explicitBounds"`
+	bucketCounts      []uint64
+}
+
+type otlpValueAtQuantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+type otlpSummaryDataPoint struct {
+	Attributes        []otlpKeyValue        `json:"attributes"`
+	StartTimeUnixNano uint64                `json:"startTimeUnixNano,string"`
+	TimeUnixNano      uint64                `json:"timeUnixNano,string"`
+	Count             uint64                `json:"count,string"`
+	Sum               float64               `json:"sum"`
+	QuantileValues    []otlpValueAtQuantile `json:"quantileValues"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                      `json:"aggregationTemporality"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryDataPoint `json:"dataPoints"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+	Summary     *otlpSummary   `json:"summary,omitempty"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+func newOtlpKeyValue(k, v string) otlpKeyValue {
+	kv := otlpKeyValue{Key: k}
+	kv.Value.StringValue = v
+	return kv
+}
+
+// otlpAttributes 指标标签转换为数据点属性，GlobalLabel 已作为资源属性，不再重复
+func otlpAttributes(labels []*dto.LabelPair) []otlpKeyValue {
+	attrs := []otlpKeyValue{}
+	for _, l := range labels {
+		if _, ok := collector.GlobalLabel[l.GetName()]; ok {
+			continue
+		}
+		attrs = append(attrs, newOtlpKeyValue(l.GetName(), l.GetValue()))
+	}
+	return attrs
+}
+
+// newOtlpRequest 把指标族转换为 OTLP 请求，计数器、直方图使用累计语义，起始时间为 Monibuca 启动时间
+func newOtlpRequest(mfs []*dto.MetricFamily, resource map[string]string, now time.Time) *otlpRequest {
+	var rm otlpResourceMetrics
+	keys := make([]string, 0, len(resource))
+	for k := range resource {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		rm.Resource.Attributes = append(rm.Resource.Attributes, newOtlpKeyValue(k, resource[k]))
+	}
+	sm := otlpScopeMetrics{
+		Scope: otlpScope{Name: "m7s.live/plugin/exporter", Version: engine.SysInfo.Version},
+	}
+
+	start := uint64(engine.SysInfo.StartTime.UnixNano())
+	nowNano := uint64(now.UnixNano())
+	for _, mf := range mfs {
+		m := otlpMetric{Name: mf.GetName(), Description: mf.GetHelp()}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			m.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+			for _, metric := range mf.GetMetric() {
+				if v := metric.GetCounter().GetValue(); !math.IsNaN(v) && !math.IsInf(v, 0) {
+					m.Sum.DataPoints = append(m.Sum.DataPoints, otlpNumberDataPoint{
+						Attributes: otlpAttributes(metric.GetLabel()), StartTimeUnixNano: start, TimeUnixNano: nowNano, AsDouble: v,
+					})
+				}
+			}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			m.Gauge = &otlpGauge{}
+			for _, metric := range mf.GetMetric() {
+				v := metric.GetGauge().GetValue()
+				if mf.GetType() == dto.MetricType_UNTYPED {
+					v = metric.GetUntyped().GetValue()
+				}
+				if !math.IsNaN(v) && !math.IsInf(v, 0) {
+					m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpNumberDataPoint{
+						Attributes: otlpAttributes(metric.GetLabel()), TimeUnixNano: nowNano, AsDouble: v,
+					})
+				}
+			}
+		case dto.MetricType_HISTOGRAM:
+			m.Histogram = &otlpHistogram{AggregationTemporality: otlpCumulative}
+			for _, metric := range mf.GetMetric() {
+				h := metric.GetHistogram()
+				dp := otlpHistogramDataPoint{
+					Attributes:        otlpAttributes(metric.GetLabel()),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      nowNano,
+					Count:             h.GetSampleCount(),
+					Sum:               h.GetSampleSum(),
+					ExplicitBounds:    []float64{},
+				}
+				//Prometheus 的分桶是累计值，OTLP 需要每个桶各自的计数，最后一个桶为 +Inf
+				var prev uint64
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						continue
+					}
+					dp.ExplicitBounds = append(dp.ExplicitBounds, b.GetUpperBound())
+					dp.bucketCounts = append(dp.bucketCounts, b.GetCumulativeCount()-prev)
+					prev = b.GetCumulativeCount()
+				}
+				dp.bucketCounts = append(dp.bucketCounts, h.GetSampleCount()-prev)
+				for _, c := range dp.bucketCounts {
+					dp.BucketCounts = append(dp.BucketCounts, strconv.FormatUint(c, 10))
+				}
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, dp)
+			}
+		case dto.MetricType_SUMMARY:
+			m.Summary = &otlpSummary{}
+			for _, metric := range mf.GetMetric() {
+				s := metric.GetSummary()
+				dp := otlpSummaryDataPoint{
+					Attributes:        otlpAttributes(metric.GetLabel()),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      nowNano,
+					Count:             s.GetSampleCount(),
+					Sum:               s.GetSampleSum(),
+					QuantileValues:    []otlpValueAtQuantile{},
+				}
+				for _, q := range s.GetQuantile() {
+					if !math.IsNaN(q.GetValue()) {
+						dp.QuantileValues = append(dp.QuantileValues, otlpValueAtQuantile{q.GetQuantile(), q.GetValue()})
+					}
+				}
+				m.Summary.DataPoints = append(m.Summary.DataPoints, dp)
+			}
+		default:
+			continue
+		}
+		sm.Metrics = append(sm.Metrics, m)
+	}
+	rm.ScopeMetrics = []otlpScopeMetrics{sm}
+	return &otlpRequest{ResourceMetrics: []otlpResourceMetrics{rm}}
+}
+
+func (r *otlpRequest) appendProto(b []byte) []byte {
+	for _, rm := range r.ResourceMetrics {
+		var rmb, res []byte
+		for _, kv := range rm.Resource.Attributes {
+			res = appendMessage(res, 1, kv.appendProto(nil))
+		}
+		rmb = appendMessage(rmb, 1, res)
+		for _, sm := range rm.ScopeMetrics {
+			var smb, scope []byte
+			scope = protowire.AppendTag(scope, 1, protowire.BytesType)
+			scope = protowire.AppendString(scope, sm.Scope.Name)
+			scope = protowire.AppendTag(scope, 2, protowire.BytesType)
+			scope = protowire.AppendString(scope, sm.Scope.Version)
+			smb = appendMessage(smb, 1, scope)
+			for _, m := range sm.Metrics {
+				smb = appendMessage(smb, 2, m.appendProto(nil))
+			}
+			rmb = appendMessage(rmb, 2, smb)
+		}
+		b = appendMessage(b, 1, rmb)
+	}
+	return b
+}
+
+func (kv otlpKeyValue) appendProto(b []byte) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, kv.Key)
+	var v []byte
+	v = protowire.AppendTag(v, 1, protowire.BytesType)
+	v = protowire.AppendString(v, kv.Value.StringValue)
+	return appendMessage(b, 2, v)
+}
+
+func (m *otlpMetric) appendProto(b []byte) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, m.Name)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, m.Description)
+	var data []byte
+	switch {
+	case m.Gauge != nil:
+		for _, dp := range m.Gauge.DataPoints {
+			data = appendMessage(data, 1, dp.appendProto(nil))
+		}
+		b = appendMessage(b, 5, data)
+	case m.Sum != nil:
+		for _, dp := range m.Sum.DataPoints {
+			data = appendMessage(data, 1, dp.appendProto(nil))
+		}
+		data = protowire.AppendTag(data, 2, protowire.VarintType)
+		data = protowire.AppendVarint(data, uint64(m.Sum.AggregationTemporality))
+		data = protowire.AppendTag(data, 3, protowire.VarintType)
+		data = protowire.AppendVarint(data, protowire.EncodeBool(m.Sum.IsMonotonic))
+		b = appendMessage(b, 7, data)
+	case m.Histogram != nil:
+		for _, dp := range m.Histogram.DataPoints {
+			data = appendMessage(data, 1, dp.appendProto(nil))
+		}
+		data = protowire.AppendTag(data, 2, protowire.VarintType)
+		data = protowire.AppendVarint(data, uint64(m.Histogram.AggregationTemporality))
+		b = appendMessage(b, 9, data)
+	case m.Summary != nil:
+		for _, dp := range m.Summary.DataPoints {
+			data = appendMessage(data, 1, dp.appendProto(nil))
+		}
+		b = appendMessage(b, 11, data)
+	}
+	return b
+}
+
+func appendTimes(b []byte, start, now uint64) []byte {
+	if start != 0 {
+		b = protowire.AppendTag(b, 2, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, start)
+	}
+	b = protowire.AppendTag(b, 3, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, now)
+}
+
+func (dp *otlpNumberDataPoint) appendProto(b []byte) []byte {
+	b = appendTimes(b, dp.StartTimeUnixNano, dp.TimeUnixNano)
+	b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(dp.AsDouble))
+	for _, kv := range dp.Attributes {
+		b = appendMessage(b, 7, kv.appendProto(nil))
+	}
+	return b
+}
+
+func (dp *otlpHistogramDataPoint) appendProto(b []byte) []byte {
+	b = appendTimes(b, dp.StartTimeUnixNano, dp.TimeUnixNano)
+	b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, dp.Count)
+	b = protowire.AppendTag(b, 5, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(dp.Sum))
+	var counts, bounds []byte
+	for _, c := range dp.bucketCounts {
+		counts = protowire.AppendFixed64(counts, c)
+	}
+	for _, bound := range dp.ExplicitBounds {
+		bounds = protowire.AppendFixed64(bounds, math.Float64bits(bound))
+	}
+	b = appendMessage(b, 6, counts)
+	b = appendMessage(b, 7, bounds)
+	for _, kv := range dp.Attributes {
+		b = appendMessage(b, 9, kv.appendProto(nil))
+	}
+	return b
+}
+
+func (dp *otlpSummaryDataPoint) appendProto(b []byte) []byte {
+	b = appendTimes(b, dp.StartTimeUnixNano, dp.TimeUnixNano)
+	b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, dp.Count)
+	b = protowire.AppendTag(b, 5, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(dp.Sum))
+	for _, q := range dp.QuantileValues {
+		var qb []byte
+		qb = protowire.AppendTag(qb, 1, protowire.Fixed64Type)
+		qb = protowire.AppendFixed64(qb, math.Float64bits(q.Quantile))
+		qb = protowire.AppendTag(qb, 2, protowire.Fixed64Type)
+		qb = protowire.AppendFixed64(qb, math.Float64bits(q.Value))
+		b = appendMessage(b, 6, qb)
+	}
+	for _, kv := range dp.Attributes {
+		b = appendMessage(b, 7, kv.appendProto(nil))
+	}
+	return b
+}
+
+// appendMessage 以 length-delimited 方式写入嵌套消息或 packed 字段
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+type otlpExporter struct {
+	conf     OtlpConfig
+	g        prometheus.Gatherer
+	resource map[string]string
+	client   *http.Client
+	results  *prometheus.CounterVec
+}
+
+func newOtlpExporter(conf OtlpConfig, resource map[string]string, g prometheus.Gatherer, reg prometheus.Registerer) *otlpExporter {
+	e := &otlpExporter{
+		conf:     conf,
+		g:        g,
+		resource: resource,
+		client:   &http.Client{Timeout: conf.Timeout},
+		results: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   collector.Namespace,
+			Subsystem:   "exporter",
+			Name:        "otlp_export_total",
+			Help:        "通过 OTLP 发送的次数，result 为 success 或 failure",
+			ConstLabels: collector.GlobalLabel,
+		}, []string{"result"}),
+	}
+	reg.MustRegister(e.results)
+	return e
+}
+
+// run 按发送间隔定时发送，直到 ctx 取消
+func (e *otlpExporter) run(ctx context.Context) {
+	ticker := time.NewTicker(e.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := e.export(ctx, now); err != nil {
+				e.results.WithLabelValues("failure").Inc()
+				log.Warn("Exporter otlp export err: ", err)
+			} else {
+				e.results.WithLabelValues("success").Inc()
+			}
+		}
+	}
+}
+
+func (e *otlpExporter) export(ctx context.Context, now time.Time) error {
+	mfs, err := e.g.Gather()
+	if err != nil {
+		log.Warn("Exporter otlp gather err: ", err)
+	}
+	otlpReq := newOtlpRequest(mfs, e.resource, now)
+	var body []byte
+	contentType := "application/x-protobuf"
+	if e.conf.Encoding == "json" {
+		contentType = "application/json"
+		if body, err = json.Marshal(otlpReq); err != nil {
+			return err
+		}
+	} else {
+		body = otlpReq.appendProto(nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
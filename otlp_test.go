@@ -0,0 +1,265 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"m7s.live/engine/v4"
+)
+
+// protoFields 把一层 protobuf 消息解码为字段号到值的映射，length-delimited 字段保留原始字节
+func protoFields(t *testing.T, b []byte) map[protowire.Number][]any {
+	t.Helper()
+	fields := map[protowire.Number][]any{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal("bad tag")
+		}
+		b = b[n:]
+		var v any
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			t.Fatalf("bad field %d", num)
+		}
+		b = b[n:]
+		fields[num] = append(fields[num], v)
+	}
+	return fields
+}
+
+// protoPath 沿着字段号逐层取第一个嵌套消息
+func protoPath(t *testing.T, b []byte, path ...protowire.Number) map[protowire.Number][]any {
+	t.Helper()
+	fields := protoFields(t, b)
+	for _, num := range path {
+		if len(fields[num]) == 0 {
+			t.Fatalf("missing field %d in path %v", num, path)
+		}
+		fields = protoFields(t, fields[num][0].([]byte))
+	}
+	return fields
+}
+
+func testOtlpFamilies() []*dto.MetricFamily {
+	label := func(name, value string) *dto.LabelPair { return &dto.LabelPair{Name: &name, Value: &value} }
+	name := func(s string) *string { return &s }
+	f := func(v float64) *float64 { return &v }
+	u := func(v uint64) *uint64 { return &v }
+	counter, gauge, histogram, summary := dto.MetricType_COUNTER, dto.MetricType_GAUGE, dto.MetricType_HISTOGRAM, dto.MetricType_SUMMARY
+	return []*dto.MetricFamily{
+		{Name: name("bytes_total"), Help: name("bytes"), Type: &counter, Metric: []*dto.Metric{
+			{Label: []*dto.LabelPair{label("type", "rtmp")}, Counter: &dto.Counter{Value: f(10)}},
+			{Label: []*dto.LabelPair{label("type", "nan")}, Counter: &dto.Counter{Value: f(math.NaN())}},
+		}},
+		{Name: name("streams"), Help: name("streams"), Type: &gauge, Metric: []*dto.Metric{
+			{Gauge: &dto.Gauge{Value: f(3)}},
+		}},
+		{Name: name("latency_seconds"), Help: name("latency"), Type: &histogram, Metric: []*dto.Metric{
+			{Histogram: &dto.Histogram{SampleCount: u(6), SampleSum: f(2.5), Bucket: []*dto.Bucket{
+				{UpperBound: f(0.1), CumulativeCount: u(1)},
+				{UpperBound: f(1), CumulativeCount: u(4)},
+				{UpperBound: f(math.Inf(1)), CumulativeCount: u(6)},
+			}}},
+		}},
+		{Name: name("gc_seconds"), Help: name("gc"), Type: &summary, Metric: []*dto.Metric{
+			{Summary: &dto.Summary{SampleCount: u(2), SampleSum: f(1), Quantile: []*dto.Quantile{
+				{Quantile: f(0.5), Value: f(0.4)},
+				{Quantile: f(0.99), Value: f(math.NaN())},
+			}}},
+		}},
+	}
+}
+
+func TestNewOtlpRequest(t *testing.T) {
+	engine.SysInfo.StartTime = time.Unix(100, 0)
+	defer func() { engine.SysInfo.StartTime = time.Time{} }()
+	now := time.Unix(200, 0)
+	req := newOtlpRequest(testOtlpFamilies(), map[string]string{"service.name": "monibuca", "host.name": "host1"}, now)
+	rm := req.ResourceMetrics[0]
+	if got := []string{rm.Resource.Attributes[0].Key, rm.Resource.Attributes[1].Key}; !reflect.DeepEqual(got, []string{"host.name", "service.name"}) {
+		t.Errorf("resource attributes = %v, want sorted keys", got)
+	}
+	metrics := rm.ScopeMetrics[0].Metrics
+	if len(metrics) != 4 {
+		t.Fatalf("got %d metrics, want 4", len(metrics))
+	}
+	start, nowNano := uint64(100e9), uint64(200e9)
+
+	tests := []struct {
+		name  string
+		got   any
+		want  any
+		field string
+	}{
+		{"counter", metrics[0].Sum.DataPoints, []otlpNumberDataPoint{
+			{Attributes: []otlpKeyValue{newOtlpKeyValue("type", "rtmp")}, StartTimeUnixNano: start, TimeUnixNano: nowNano, AsDouble: 10},
+		}, "sum"},
+		{"counter temporality", [2]any{metrics[0].Sum.AggregationTemporality, metrics[0].Sum.IsMonotonic}, [2]any{otlpCumulative, true}, "sum"},
+		{"gauge", metrics[1].Gauge.DataPoints, []otlpNumberDataPoint{
+			{Attributes: []otlpKeyValue{}, TimeUnixNano: nowNano, AsDouble: 3},
+		}, "gauge"},
+		{"histogram buckets", [2]any{metrics[2].Histogram.DataPoints[0].BucketCounts, metrics[2].Histogram.DataPoints[0].ExplicitBounds},
+			[2]any{[]string{"1", "3", "2"}, []float64{0.1, 1}}, "histogram"},
+		{"summary quantiles", metrics[3].Summary.DataPoints[0].QuantileValues, []otlpValueAtQuantile{{0.5, 0.4}}, "summary"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("%s = %+v, want %+v", tt.field, tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOtlpRequestProto(t *testing.T) {
+	engine.SysInfo.StartTime = time.Unix(100, 0)
+	defer func() { engine.SysInfo.StartTime = time.Time{} }()
+	req := newOtlpRequest(testOtlpFamilies(), map[string]string{"service.name": "monibuca"}, time.Unix(200, 0))
+	b := req.appendProto(nil)
+
+	attr := protoPath(t, b, 1, 1, 1)
+	if key := string(attr[1][0].([]byte)); key != "service.name" {
+		t.Errorf("resource attribute key = %q", key)
+	}
+	if value := protoPath(t, attr[2][0].([]byte)); string(value[1][0].([]byte)) != "monibuca" {
+		t.Errorf("resource attribute value = %q", value[1][0])
+	}
+	sm := protoPath(t, b, 1, 2)
+	if len(sm[2]) != 4 {
+		t.Fatalf("got %d metrics, want 4", len(sm[2]))
+	}
+	metric := func(i int) map[protowire.Number][]any { return protoFields(t, sm[2][i].([]byte)) }
+
+	tests := []struct {
+		name   string
+		metric int
+		data   protowire.Number //Metric 中数据的字段号
+		check  func(t *testing.T, data, dp map[protowire.Number][]any)
+	}{
+		{"sum", 0, 7, func(t *testing.T, data, dp map[protowire.Number][]any) {
+			if data[2][0].(uint64) != otlpCumulative || data[3][0].(uint64) != 1 {
+				t.Errorf("temporality = %v, monotonic = %v", data[2], data[3])
+			}
+			if dp[2][0].(uint64) != 100e9 || dp[3][0].(uint64) != 200e9 {
+				t.Errorf("times = %v %v", dp[2], dp[3])
+			}
+			if v := math.Float64frombits(dp[4][0].(uint64)); v != 10 {
+				t.Errorf("value = %v, want 10", v)
+			}
+			if len(dp[7]) != 1 {
+				t.Errorf("got %d attributes, want 1", len(dp[7]))
+			}
+		}},
+		{"gauge", 1, 5, func(t *testing.T, data, dp map[protowire.Number][]any) {
+			if len(dp[2]) != 0 {
+				t.Error("gauge has start time")
+			}
+			if v := math.Float64frombits(dp[4][0].(uint64)); v != 3 {
+				t.Errorf("value = %v, want 3", v)
+			}
+		}},
+		{"histogram", 2, 9, func(t *testing.T, data, dp map[protowire.Number][]any) {
+			if dp[4][0].(uint64) != 6 || math.Float64frombits(dp[5][0].(uint64)) != 2.5 {
+				t.Errorf("count = %v, sum = %v", dp[4], dp[5])
+			}
+			counts := dp[6][0].([]byte)
+			var got []uint64
+			for len(counts) > 0 {
+				v, n := protowire.ConsumeFixed64(counts)
+				got = append(got, v)
+				counts = counts[n:]
+			}
+			if !reflect.DeepEqual(got, []uint64{1, 3, 2}) {
+				t.Errorf("bucket counts = %v", got)
+			}
+			if len(dp[7][0].([]byte)) != 16 {
+				t.Errorf("explicit bounds = %v", dp[7])
+			}
+		}},
+		{"summary", 3, 11, func(t *testing.T, data, dp map[protowire.Number][]any) {
+			if len(dp[6]) != 1 {
+				t.Fatalf("got %d quantiles, want 1", len(dp[6]))
+			}
+			q := protoFields(t, dp[6][0].([]byte))
+			if math.Float64frombits(q[1][0].(uint64)) != 0.5 || math.Float64frombits(q[2][0].(uint64)) != 0.4 {
+				t.Errorf("quantile = %v", q)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := metric(tt.metric)
+			if len(m[tt.data]) != 1 {
+				t.Fatalf("missing data field %d", tt.data)
+			}
+			data := protoFields(t, m[tt.data][0].([]byte))
+			tt.check(t, data, protoFields(t, data[1][0].([]byte)))
+		})
+	}
+}
+
+func TestOtlpExport(t *testing.T) {
+	tests := []struct {
+		encoding    string
+		contentType string
+	}{
+		{"protobuf", "application/x-protobuf"},
+		{"json", "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			var body []byte
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if ct := r.Header.Get("Content-Type"); ct != tt.contentType {
+					t.Errorf("Content-Type = %q, want %q", ct, tt.contentType)
+				}
+				body, _ = io.ReadAll(r.Body)
+			}))
+			defer srv.Close()
+
+			g := prometheus.NewPedanticRegistry()
+			up := prometheus.NewGauge(prometheus.GaugeOpts{Name: "monibuca_up", Help: "up"})
+			up.Set(1)
+			g.MustRegister(up)
+			conf := OtlpConfig{URL: srv.URL, Encoding: tt.encoding, Interval: time.Second, Timeout: time.Second}
+			e := newOtlpExporter(conf, map[string]string{"service.name": "monibuca"}, g, prometheus.NewRegistry())
+			if err := e.export(context.Background(), time.Now()); err != nil {
+				t.Fatal(err)
+			}
+
+			var name string
+			if tt.encoding == "json" {
+				var req otlpRequest
+				if err := json.Unmarshal(body, &req); err != nil {
+					t.Fatal(err)
+				}
+				name = req.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Name
+			} else {
+				name = string(protoPath(t, body, 1, 2, 2)[1][0].([]byte))
+			}
+			if name != "monibuca_up" {
+				t.Errorf("metric name = %q, want monibuca_up", name)
+			}
+		})
+	}
+}
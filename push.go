@@ -0,0 +1,99 @@
+package exporter
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"m7s.live/engine/v4/log"
+	"m7s.live/plugin/exporter/v4/collector"
+	"net/http"
+	"time"
+)
+
+type PushConfig struct {
+	URL      string        //Pushgateway 地址，为空不推送
+	Job      string        //job 名称
+	Interval time.Duration //推送间隔
+	Retry    int           //每次推送失败后的重试次数，重试间隔从 1 秒开始翻倍
+	Username string        //Pushgateway 的 basic auth 用户名
+	Password string        //Pushgateway 的 basic auth 密码
+}
+
+func (c PushConfig) validate() error {
+	return checkPositive("interval", c.Interval)
+}
+
+type pusher struct {
+	*push.Pusher
+	conf     PushConfig
+	results  *prometheus.CounterVec
+	lastPush prometheus.Gauge
+}
+
+func newPusher(conf PushConfig, instance string, g prometheus.Gatherer, reg prometheus.Registerer) *pusher {
+	p := &pusher{
+		conf: conf,
+		results: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   collector.Namespace,
+			Subsystem:   "exporter",
+			Name:        "push_total",
+			Help:        "推送到 Pushgateway 的次数，result 为 success 或 failure",
+			ConstLabels: collector.GlobalLabel,
+		}, []string{"result"}),
+		lastPush: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   collector.Namespace,
+			Subsystem:   "exporter",
+			Name:        "push_last_success_timestamp_seconds",
+			Help:        "最近一次成功推送到 Pushgateway 的时间戳",
+			ConstLabels: collector.GlobalLabel,
+		}),
+	}
+	reg.MustRegister(p.results, p.lastPush)
+	p.Pusher = push.New(conf.URL, conf.Job).
+		Gatherer(g).
+		Grouping("instance", instance).
+		Client(&http.Client{Timeout: conf.Interval})
+	if conf.Username != "" {
+		p.Pusher = p.BasicAuth(conf.Username, conf.Password)
+	}
+	return p
+}
+
+// run 按推送间隔定时推送，直到 ctx 取消
+func (p *pusher) run(ctx context.Context) {
+	ticker := time.NewTicker(p.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.pushWithRetry(ctx)
+		}
+	}
+}
+
+func (p *pusher) pushWithRetry(ctx context.Context) {
+	backoff := time.Second
+	for i := 0; ; i++ {
+		err := p.PushContext(ctx)
+		if err == nil {
+			p.results.WithLabelValues("success").Inc()
+			p.lastPush.SetToCurrentTime()
+			return
+		}
+		p.results.WithLabelValues("failure").Inc()
+		if i >= p.conf.Retry {
+			log.Warn("Exporter push to pushgateway err: ", err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > p.conf.Interval {
+			backoff = p.conf.Interval
+		}
+	}
+}
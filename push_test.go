@@ -0,0 +1,109 @@
+package exporter
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPushWithRetry(t *testing.T) {
+	tests := []struct {
+		name        string
+		conf        PushConfig
+		statuses    []int //Pushgateway 依次返回的状态码，用完后一直返回最后一个
+		wantReqs    int
+		wantSuccess float64
+		wantFailure float64
+	}{
+		{
+			name:        "success",
+			conf:        PushConfig{Job: "monibuca", Interval: 10 * time.Millisecond},
+			statuses:    []int{http.StatusOK},
+			wantReqs:    1,
+			wantSuccess: 1,
+		},
+		{
+			name:        "basic auth",
+			conf:        PushConfig{Job: "monibuca", Interval: 10 * time.Millisecond, Username: "user", Password: "pass"},
+			statuses:    []int{http.StatusOK},
+			wantReqs:    1,
+			wantSuccess: 1,
+		},
+		{
+			name:        "retry then success",
+			conf:        PushConfig{Job: "monibuca", Interval: 10 * time.Millisecond, Retry: 3},
+			statuses:    []int{http.StatusInternalServerError, http.StatusOK},
+			wantReqs:    2,
+			wantSuccess: 1,
+			wantFailure: 1,
+		},
+		{
+			name:        "retry exhausted",
+			conf:        PushConfig{Job: "monibuca", Interval: 10 * time.Millisecond, Retry: 2},
+			statuses:    []int{http.StatusInternalServerError},
+			wantReqs:    3,
+			wantFailure: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				bodies []string
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPut || r.URL.Path != "/metrics/job/monibuca/instance/host@zh_cn" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				if user, pass, ok := r.BasicAuth(); tt.conf.Username != "" && (!ok || user != tt.conf.Username || pass != tt.conf.Password) {
+					t.Errorf("unexpected basic auth %q %q", user, pass)
+				}
+				body, _ := io.ReadAll(r.Body)
+				mu.Lock()
+				bodies = append(bodies, string(body))
+				status := tt.statuses[len(tt.statuses)-1]
+				if len(bodies) <= len(tt.statuses) {
+					status = tt.statuses[len(bodies)-1]
+				}
+				mu.Unlock()
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+
+			g := prometheus.NewPedanticRegistry()
+			up := prometheus.NewGauge(prometheus.GaugeOpts{Name: "monibuca_up", Help: "up"})
+			up.Set(1)
+			g.MustRegister(up)
+			conf := tt.conf
+			conf.URL = srv.URL
+			p := newPusher(conf, "host@zh_cn", g, prometheus.NewRegistry())
+			p.pushWithRetry(context.Background())
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(bodies) != tt.wantReqs {
+				t.Errorf("got %d requests, want %d", len(bodies), tt.wantReqs)
+			}
+			if len(bodies) > 0 && !strings.Contains(bodies[0], "monibuca_up") {
+				t.Error("pushed body does not contain monibuca_up")
+			}
+			if got := testutil.ToFloat64(p.results.WithLabelValues("success")); got != tt.wantSuccess {
+				t.Errorf("success = %v, want %v", got, tt.wantSuccess)
+			}
+			if got := testutil.ToFloat64(p.results.WithLabelValues("failure")); got != tt.wantFailure {
+				t.Errorf("failure = %v, want %v", got, tt.wantFailure)
+			}
+			if last := testutil.ToFloat64(p.lastPush); (last > 0) != (tt.wantSuccess > 0) {
+				t.Errorf("last success timestamp = %v", last)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"m7s.live/engine/v4/config"
+	"m7s.live/engine/v4/log"
+	"m7s.live/plugin/exporter/v4/collector"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// swapGatherer 热加载时原子地替换采集器集合，已经开始的抓取继续使用旧的集合完成，
+// 指标接口、看板以及各种推送持有的都是它，替换后下一次采集即生效
+type swapGatherer struct {
+	v atomic.Value
+}
+
+func (g *swapGatherer) store(gatherer prometheus.Gatherer) {
+	g.v.Store(&gatherer)
+}
+
+func (g *swapGatherer) Gather() ([]*dto.MetricFamily, error) {
+	return (*g.v.Load().(*prometheus.Gatherer)).Gather()
+}
+
+// collectorConfig 返回 exporter.collector 下某个采集器的配置，没有配置时返回 nil
+func collectorConfig(cfg config.Config, name string) (config.Config, error) {
+	if !cfg.Has(name) {
+		return nil, nil
+	}
+	cCfg, ok := cfg.Get(name).(config.Config)
+	if !ok {
+		return nil, fmt.Errorf("config is not map")
+	}
+	return cCfg, nil
+}
+
+// reload 按当前的 Enabled 和 CollectorConfig 加载采集器，已经在运行且配置没有变化的采集器保持不变，
+// 只重建新增或配置变化的采集器，构建失败时保留原来的采集器，最后原子地替换 Gatherer 并停止被移除的采集器
+func (p *ExporterConfig) reload() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	collectors := make(map[string]collector.Collector)
+	fingerprints := make(map[string]string)
+	cancels := make(map[string]context.CancelFunc)
+	buildErrors := make(map[string]error)
+	keep := func(name string) {
+		collectors[name], fingerprints[name], cancels[name] = p.collectors[name], p.fingerprints[name], p.cancels[name]
+	}
+	collector.DefaultTimeout = p.Timeout
+	for _, name := range expandEnabledCollectors(p.Enabled) {
+		_, running := p.collectors[name]
+		cCfg, err := collectorConfig(p.CollectorConfig, name)
+		if err != nil {
+			log.Warnf("Exporter loadCollector %s config err, %s", name, err)
+			buildErrors[name] = err
+			if running {
+				keep(name)
+			}
+			continue
+		}
+		// fmt 输出 map 时按键排序，可以用来比较配置是否变化，默认超时变化时也需要重建
+		fingerprint := fmt.Sprint(p.Timeout, cCfg)
+		if running && p.fingerprints[name] == fingerprint {
+			keep(name)
+			continue
+		}
+		c, err := collector.Build(name, cCfg)
+		if err != nil {
+			log.Warnf("Exporter loadCollector %s err: %s", name, err)
+			buildErrors[name] = err
+			if running {
+				keep(name)
+			}
+			continue
+		}
+		if running {
+			log.Infof("Exporter reload collector %s", name)
+		}
+		ctx, cancel := context.WithCancel(plugin)
+		collector.Start(ctx, c)
+		collectors[name], fingerprints[name], cancels[name] = c, fingerprint, cancel
+	}
+	reg := prometheus.NewPedanticRegistry()
+	for name, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			log.Warnf("Exporter register collector %s err: %s", name, err)
+			buildErrors[name] = err
+			if c != p.collectors[name] {
+				cancels[name]()
+			}
+			delete(collectors, name)
+			delete(fingerprints, name)
+			delete(cancels, name)
+		}
+	}
+	p.swap.store(prometheus.Gatherers{
+		prometheus.DefaultGatherer,
+		p.registry,
+		reg,
+	})
+	for name, c := range p.collectors {
+		if collectors[name] != c {
+			if _, ok := collectors[name]; !ok {
+				log.Infof("Exporter remove collector %s", name)
+			}
+			p.cancels[name]()
+		}
+	}
+	p.collectors, p.fingerprints, p.cancels, p.buildErrors = collectors, fingerprints, cancels, buildErrors
+}
+
+// enabledCollectors 返回当前启用的采集器的快照
+func (p *ExporterConfig) enabledCollectors() map[string]collector.Collector {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	collectors := make(map[string]collector.Collector, len(p.collectors))
+	for name, c := range p.collectors {
+		collectors[name] = c
+	}
+	return collectors
+}
+
+// createdTimes 返回启用的采集器自己累加的计数器的起点，OpenMetrics 输出 _created 时使用
+func (p *ExporterConfig) createdTimes() map[string]time.Time {
+	created := make(map[string]time.Time)
+	for _, c := range p.enabledCollectors() {
+		for name, t := range collector.CreatedOf(c) {
+			created[name] = t
+		}
+	}
+	return created
+}
+
+// applyConfig 从插件配置中读取 enabled、timeout 和 collector，配置变更时可能只包含修改过的项，没有的项保持不变
+func (p *ExporterConfig) applyConfig(cfg config.Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if enabled, ok := cfg.Get("enabled").(string); ok {
+		p.Enabled = enabled
+	}
+	if cfg.Has("timeout") {
+		if timeout, err := parseDuration(cfg.Get("timeout")); err != nil {
+			log.Warnf("Exporter config timeout err: %s", err)
+		} else {
+			p.Timeout = timeout
+		}
+	}
+	if cfg.Has("collector") {
+		p.CollectorConfig = cfg.GetChild("collector")
+	}
+}
+
+// parseDuration 解析配置中的时长，可以是 5s 这样的字符串或者纳秒数
+func parseDuration(v any) (time.Duration, error) {
+	switch v := v.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		return time.ParseDuration(v)
+	case int:
+		return time.Duration(v), nil
+	case int64:
+		return time.Duration(v), nil
+	case float64:
+		return time.Duration(v), nil
+	}
+	return 0, fmt.Errorf("invalid duration %v", v)
+}
+
+// API_reload 重新读取插件配置并重新加载采集器，返回加载后启用的采集器
+func (p *ExporterConfig) API_reload(w http.ResponseWriter, r *http.Request) {
+	if !p.accept(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	p.applyConfig(plugin.RawConfig)
+	p.reload()
+	names := []string{}
+	for name := range p.enabledCollectors() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"collectors": names})
+}
@@ -0,0 +1,184 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"m7s.live/engine/v4/config"
+	"m7s.live/plugin/exporter/v4/collector"
+)
+
+// reloadTestCollector 记录构建时的配置，Run 在 ctx 取消后关闭 stopped
+type reloadTestCollector struct {
+	desc    *prometheus.Desc
+	cfg     config.Config
+	stopped chan struct{}
+}
+
+func (c *reloadTestCollector) Describe(ch chan<- *prometheus.Desc) { ch <- c.desc }
+
+func (c *reloadTestCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1)
+}
+
+func (c *reloadTestCollector) OnEvent(event any) {}
+
+func (c *reloadTestCollector) Run(ctx context.Context) {
+	<-ctx.Done()
+	close(c.stopped)
+}
+
+func init() {
+	for _, name := range []string{"test_reload_a", "test_reload_b"} {
+		name := name
+		collector.RegisterCollector(name, func(cfg config.Config) (collector.Collector, error) {
+			if cfg.Has("fail") {
+				return nil, errors.New("build failed")
+			}
+			return &reloadTestCollector{
+				desc:    prometheus.NewDesc(name, "test", nil, nil),
+				cfg:     cfg,
+				stopped: make(chan struct{}),
+			}, nil
+		})
+	}
+}
+
+func newTestExporter(enabled string) *ExporterConfig {
+	p := &ExporterConfig{
+		Enabled:         enabled,
+		CollectorConfig: config.Config{},
+		Timeout:         time.Second,
+		collectors:      make(map[string]collector.Collector),
+	}
+	p.gatherer = initExporter(p)
+	p.h = newHandler(p.gatherer, p.createdTimes)
+	return p
+}
+
+// inner 返回框架包装之前的测试采集器
+func inner(t *testing.T, p *ExporterConfig, name string) *reloadTestCollector {
+	t.Helper()
+	c, ok := p.enabledCollectors()[name]
+	if !ok {
+		t.Fatalf("collector %s is not enabled", name)
+	}
+	return collector.Unwrap(c).(*reloadTestCollector)
+}
+
+func stopped(c *reloadTestCollector) bool {
+	select {
+	case <-c.stopped:
+		return true
+	case <-time.After(100 * time.Millisecond):
+		return false
+	}
+}
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name        string
+		change      func(p *ExporterConfig)
+		rebuilt     map[string]bool //采集器是否重建，重建时旧的采集器应当被停止
+		removed     []string
+		buildErrors []string
+	}{
+		{
+			name:    "unchanged",
+			change:  func(p *ExporterConfig) {},
+			rebuilt: map[string]bool{"test_reload_a": false, "test_reload_b": false},
+		},
+		{
+			name: "collector config changed",
+			change: func(p *ExporterConfig) {
+				p.CollectorConfig = config.Config{"test_reload_a": config.Config{"foo": "bar"}}
+			},
+			rebuilt: map[string]bool{"test_reload_a": true, "test_reload_b": false},
+		},
+		{
+			name:    "default timeout changed",
+			change:  func(p *ExporterConfig) { p.Timeout = 2 * time.Second },
+			rebuilt: map[string]bool{"test_reload_a": true, "test_reload_b": true},
+		},
+		{
+			name: "build failure keeps the old collector",
+			change: func(p *ExporterConfig) {
+				p.CollectorConfig = config.Config{"test_reload_a": config.Config{"fail": true}}
+			},
+			rebuilt:     map[string]bool{"test_reload_a": false, "test_reload_b": false},
+			buildErrors: []string{"test_reload_a"},
+		},
+		{
+			name:    "removed collector is stopped",
+			change:  func(p *ExporterConfig) { p.Enabled = "test_reload_a" },
+			rebuilt: map[string]bool{"test_reload_a": false},
+			removed: []string{"test_reload_b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestExporter("test_reload_a,test_reload_b")
+			before := map[string]*reloadTestCollector{}
+			for name := range p.enabledCollectors() {
+				before[name] = inner(t, p, name)
+			}
+			tt.change(p)
+			p.reload()
+
+			for name, rebuilt := range tt.rebuilt {
+				after := inner(t, p, name)
+				if (after != before[name]) != rebuilt {
+					t.Errorf("%s rebuilt = %v, want %v", name, after != before[name], rebuilt)
+				}
+				if stopped(before[name]) != rebuilt {
+					t.Errorf("old %s stopped = %v, want %v", name, !rebuilt, rebuilt)
+				}
+			}
+			for _, name := range tt.removed {
+				if _, ok := p.enabledCollectors()[name]; ok {
+					t.Errorf("%s is still enabled", name)
+				}
+				if !stopped(before[name]) {
+					t.Errorf("removed %s is not stopped", name)
+				}
+			}
+			for _, name := range tt.buildErrors {
+				if p.collectorStatus(name).BuildError == "" {
+					t.Errorf("%s has no build error", name)
+				}
+			}
+			mfs, err := p.swap.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			gathered := map[string]bool{}
+			for _, mf := range mfs {
+				gathered[mf.GetName()] = true
+			}
+			for name := range p.enabledCollectors() {
+				if !gathered[name] {
+					t.Errorf("%s is not gathered", name)
+				}
+			}
+		})
+	}
+}
+
+// TestReloadBase base 的配置变化时会被重建，重建不能因为重复注册 panic
+func TestReloadBase(t *testing.T) {
+	p := newTestExporter("base")
+	for i := 0; i < 3; i++ {
+		p.Timeout = time.Duration(i+2) * time.Second
+		p.reload()
+		if status := p.collectorStatus("base"); !status.Enabled || status.BuildError != "" {
+			t.Fatalf("reload %d: base status %+v", i, status)
+		}
+	}
+	if _, err := p.swap.Gather(); err != nil {
+		t.Fatal(fmt.Errorf("gather after rebuilding base: %w", err))
+	}
+}
@@ -0,0 +1,268 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+	"hash/fnv"
+	"io"
+	"m7s.live/engine/v4/log"
+	"m7s.live/plugin/exporter/v4/collector"
+	"math"
+	"net/http"
+	"time"
+)
+
+type RemoteWriteConfig struct {
+	URL         string        //remote_write 地址，比如 http://victoriametrics:8428/api/v1/write，为空不发送
+	Interval    time.Duration //采集间隔
+	Shards      int           //并发发送的分片数
+	QueueSize   int           //每个分片最多缓存的样本数，超出后丢弃
+	BatchSize   int           //每个请求最多包含的样本数
+	Timeout     time.Duration //请求超时
+	MaxBackoff  time.Duration //重试的最大间隔
+	Username    string        //basic auth 用户名
+	Password    string        //basic auth 密码
+	BearerToken string        //bearer token
+}
+
+func (c RemoteWriteConfig) validate() error {
+	if err := checkPositive("interval", c.Interval); err != nil {
+		return err
+	}
+	if err := checkPositive("maxbackoff", c.MaxBackoff); err != nil {
+		return err
+	}
+	if c.QueueSize <= 0 || c.BatchSize <= 0 {
+		return fmt.Errorf("invalid queuesize %d or batchsize %d", c.QueueSize, c.BatchSize)
+	}
+	return nil
+}
+
+type remoteWriter struct {
+	conf   RemoteWriteConfig
+	g      prometheus.Gatherer
+	client *http.Client
+	queues []chan sample
+
+	sent    prometheus.Counter
+	dropped *prometheus.CounterVec
+	failed  prometheus.Counter
+}
+
+func newRemoteWriter(conf RemoteWriteConfig, g prometheus.Gatherer, reg prometheus.Registerer) *remoteWriter {
+	if conf.Shards <= 0 {
+		conf.Shards = 1
+	}
+	w := &remoteWriter{
+		conf:   conf,
+		g:      g,
+		client: &http.Client{Timeout: conf.Timeout},
+		queues: make([]chan sample, conf.Shards),
+		sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   collector.Namespace,
+			Subsystem:   "exporter",
+			Name:        "remote_write_sent_samples_total",
+			Help:        "remote_write 发送成功的样本总数",
+			ConstLabels: collector.GlobalLabel,
+		}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   collector.Namespace,
+			Subsystem:   "exporter",
+			Name:        "remote_write_dropped_samples_total",
+			Help:        "remote_write 丢弃的样本总数，reason 为 queue_full 或 rejected",
+			ConstLabels: collector.GlobalLabel,
+		}, []string{"reason"}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   collector.Namespace,
+			Subsystem:   "exporter",
+			Name:        "remote_write_failed_requests_total",
+			Help:        "remote_write 失败的请求总数",
+			ConstLabels: collector.GlobalLabel,
+		}),
+	}
+	for i := range w.queues {
+		w.queues[i] = make(chan sample, conf.QueueSize)
+	}
+	queueLength := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   collector.Namespace,
+		Subsystem:   "exporter",
+		Name:        "remote_write_queue_length",
+		Help:        "remote_write 队列中待发送的样本数",
+		ConstLabels: collector.GlobalLabel,
+	}, func() float64 {
+		n := 0
+		for _, q := range w.queues {
+			n += len(q)
+		}
+		return float64(n)
+	})
+	reg.MustRegister(w.sent, w.dropped, w.failed, queueLength)
+	return w
+}
+
+// run 定时采集并入队，每个分片独立发送，直到 ctx 取消
+func (w *remoteWriter) run(ctx context.Context) {
+	for _, q := range w.queues {
+		go w.runShard(ctx, q)
+	}
+	ticker := time.NewTicker(w.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.enqueue(now)
+		}
+	}
+}
+
+func (w *remoteWriter) enqueue(now time.Time) {
+	mfs, err := w.g.Gather()
+	if err != nil {
+		log.Warn("Exporter remote_write gather err: ", err)
+	}
+	for _, s := range flatten(mfs, now) {
+		// 同一序列总是进入同一个分片，保证序列内样本有序
+		h := fnv.New32a()
+		h.Write([]byte(s.Name))
+		for _, l := range s.Labels {
+			h.Write([]byte{0xff})
+			h.Write([]byte(l.Name))
+			h.Write([]byte{0xff})
+			h.Write([]byte(l.Value))
+		}
+		select {
+		case w.queues[h.Sum32()%uint32(len(w.queues))] <- s:
+		default:
+			w.dropped.WithLabelValues("queue_full").Inc()
+		}
+	}
+}
+
+func (w *remoteWriter) runShard(ctx context.Context, q chan sample) {
+	batch := make([]sample, 0, w.conf.BatchSize)
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-q:
+			if batch = append(batch, s); len(batch) >= w.conf.BatchSize {
+				w.sendWithRetry(ctx, batch)
+				batch = batch[:0]
+			}
+		case <-flush.C:
+			if len(batch) > 0 {
+				w.sendWithRetry(ctx, batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// sendWithRetry 遇到网络错误、5xx 和 429 时按指数退避重试，其它错误直接丢弃这批样本
+func (w *remoteWriter) sendWithRetry(ctx context.Context, batch []sample) {
+	body := snappy.Encode(nil, encodeWriteRequest(batch))
+	backoff := 100 * time.Millisecond
+	for {
+		retry, err := w.send(ctx, body)
+		if err == nil {
+			w.sent.Add(float64(len(batch)))
+			return
+		}
+		w.failed.Inc()
+		if !retry {
+			log.Warn("Exporter remote_write err: ", err)
+			w.dropped.WithLabelValues("rejected").Add(float64(len(batch)))
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > w.conf.MaxBackoff {
+			backoff = w.conf.MaxBackoff
+		}
+	}
+}
+
+func (w *remoteWriter) send(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if w.conf.Username != "" {
+		req.SetBasicAuth(w.conf.Username, w.conf.Password)
+	} else if w.conf.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+w.conf.BearerToken)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	err = fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, msg)
+	return resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// encodeWriteRequest 按 remote_write 协议的 prometheus.WriteRequest 编码样本，
+// 同一序列的样本合并到一个 TimeSeries 中，序列按在批次中第一次出现的顺序排列，
+// 同一分片的队列按时间顺序入队，所以每个序列内的样本也按时间排列
+func encodeWriteRequest(batch []sample) []byte {
+	var (
+		keys   []string
+		series = make(map[string][]sample)
+	)
+	for _, s := range batch {
+		key := seriesKey(s.Name, s.Labels)
+		if _, ok := series[key]; !ok {
+			keys = append(keys, key)
+		}
+		series[key] = append(series[key], s)
+	}
+	var buf []byte
+	for _, key := range keys {
+		samples := series[key]
+		var ts []byte
+		ts = appendLabel(ts, "__name__", samples[0].Name)
+		for _, l := range samples[0].Labels {
+			ts = appendLabel(ts, l.Name, l.Value)
+		}
+		for _, s := range samples {
+			var smp []byte
+			smp = protowire.AppendTag(smp, 1, protowire.Fixed64Type)
+			smp = protowire.AppendFixed64(smp, math.Float64bits(s.Value))
+			smp = protowire.AppendTag(smp, 2, protowire.VarintType)
+			smp = protowire.AppendVarint(smp, uint64(s.Timestamp))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, smp)
+		}
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+	return buf
+}
+
+func appendLabel(b []byte, name, value string) []byte {
+	var l []byte
+	l = protowire.AppendTag(l, 1, protowire.BytesType)
+	l = protowire.AppendString(l, name)
+	l = protowire.AppendTag(l, 2, protowire.BytesType)
+	l = protowire.AppendString(l, value)
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, l)
+}
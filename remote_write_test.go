@@ -0,0 +1,185 @@
+package exporter
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type decodedSeries struct {
+	Labels  []labelPair
+	Samples [][2]float64 //值和毫秒时间戳
+}
+
+// decodeWriteRequest 解码 encodeWriteRequest 的输出，只处理用到的字段
+func decodeWriteRequest(t *testing.T, b []byte) []decodedSeries {
+	t.Helper()
+	var result []decodedSeries
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 || num != 1 || typ != protowire.BytesType {
+			t.Fatalf("unexpected WriteRequest field %d type %d", num, typ)
+		}
+		b = b[n:]
+		ts, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			t.Fatal("bad TimeSeries")
+		}
+		b = b[n:]
+		var series decodedSeries
+		for len(ts) > 0 {
+			num, _, n := protowire.ConsumeTag(ts)
+			ts = ts[n:]
+			msg, n := protowire.ConsumeBytes(ts)
+			if n < 0 {
+				t.Fatal("bad TimeSeries field")
+			}
+			ts = ts[n:]
+			fields := map[protowire.Number]uint64{}
+			var strs []string
+			for len(msg) > 0 {
+				fnum, ftyp, n := protowire.ConsumeTag(msg)
+				msg = msg[n:]
+				switch ftyp {
+				case protowire.BytesType:
+					v, n := protowire.ConsumeBytes(msg)
+					strs = append(strs, string(v))
+					msg = msg[n:]
+				case protowire.Fixed64Type:
+					v, n := protowire.ConsumeFixed64(msg)
+					fields[fnum] = v
+					msg = msg[n:]
+				case protowire.VarintType:
+					v, n := protowire.ConsumeVarint(msg)
+					fields[fnum] = v
+					msg = msg[n:]
+				default:
+					t.Fatalf("unexpected wire type %d", ftyp)
+				}
+			}
+			switch num {
+			case 1:
+				series.Labels = append(series.Labels, labelPair{strs[0], strs[1]})
+			case 2:
+				series.Samples = append(series.Samples, [2]float64{math.Float64frombits(fields[1]), float64(fields[2])})
+			default:
+				t.Fatalf("unexpected TimeSeries field %d", num)
+			}
+		}
+		result = append(result, series)
+	}
+	return result
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	up := []labelPair{{"job", "a"}}
+	down := []labelPair{{"job", "b"}}
+	tests := []struct {
+		name  string
+		batch []sample
+		want  []decodedSeries
+	}{
+		{
+			name:  "single",
+			batch: []sample{{Name: "up", Labels: up, Value: 1, Timestamp: 1000}},
+			want: []decodedSeries{
+				{Labels: []labelPair{{"__name__", "up"}, {"job", "a"}}, Samples: [][2]float64{{1, 1000}}},
+			},
+		},
+		{
+			name: "grouped by series",
+			batch: []sample{
+				{Name: "up", Labels: up, Value: 1, Timestamp: 1000},
+				{Name: "up", Labels: down, Value: 0, Timestamp: 1000},
+				{Name: "up", Labels: up, Value: 1, Timestamp: 2000},
+				{Name: "errors", Value: 2.5, Timestamp: 2000},
+				{Name: "up", Labels: down, Value: 1, Timestamp: 2000},
+			},
+			want: []decodedSeries{
+				{Labels: []labelPair{{"__name__", "up"}, {"job", "a"}}, Samples: [][2]float64{{1, 1000}, {1, 2000}}},
+				{Labels: []labelPair{{"__name__", "up"}, {"job", "b"}}, Samples: [][2]float64{{0, 1000}, {1, 2000}}},
+				{Labels: []labelPair{{"__name__", "errors"}}, Samples: [][2]float64{{2.5, 2000}}},
+			},
+		},
+		{
+			name:  "empty",
+			batch: nil,
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeWriteRequest(t, encodeWriteRequest(tt.batch))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoteWriteSend(t *testing.T) {
+	var batch []sample
+	for i := 0; i < 100; i++ {
+		batch = append(batch, sample{Name: "up", Labels: []labelPair{{"job", "monibuca"}}, Value: 1, Timestamp: int64(i) * 1000})
+	}
+	received := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- body
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	w := newRemoteWriter(RemoteWriteConfig{URL: srv.URL, Timeout: time.Second, MaxBackoff: time.Second}, nil, prometheus.NewRegistry())
+	w.sendWithRetry(context.Background(), batch)
+	body := <-received
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) >= len(raw) {
+		t.Errorf("body is not compressed: %d >= %d", len(body), len(raw))
+	}
+	series := decodeWriteRequest(t, raw)
+	if len(series) != 1 || len(series[0].Samples) != len(batch) {
+		t.Errorf("got %d series, want 1 series with %d samples", len(series), len(batch))
+	}
+}
+
+func TestRemoteWriteConfigValidate(t *testing.T) {
+	valid := RemoteWriteConfig{Interval: time.Second, MaxBackoff: time.Second, QueueSize: 10, BatchSize: 5}
+	tests := []struct {
+		name    string
+		modify  func(c *RemoteWriteConfig)
+		wantErr bool
+	}{
+		{"valid", func(c *RemoteWriteConfig) {}, false},
+		{"zero interval", func(c *RemoteWriteConfig) { c.Interval = 0 }, true},
+		{"negative maxbackoff", func(c *RemoteWriteConfig) { c.MaxBackoff = -time.Second }, true},
+		{"zero queuesize", func(c *RemoteWriteConfig) { c.QueueSize = 0 }, true},
+		{"negative queuesize", func(c *RemoteWriteConfig) { c.QueueSize = -1 }, true},
+		{"zero batchsize", func(c *RemoteWriteConfig) { c.BatchSize = 0 }, true},
+		{"negative batchsize", func(c *RemoteWriteConfig) { c.BatchSize = -1 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid
+			tt.modify(&c)
+			if err := c.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package exporter
+
+import (
+	dto "github.com/prometheus/client_model/go"
+	"m7s.live/plugin/exporter/v4/collector"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type labelPair struct {
+	Name  string
+	Value string
+}
+
+// sample 指标族展开后的单个样本，直方图和摘要会按 Prometheus 的惯例展开成
+// _bucket、_sum、_count 等多个样本，Labels 按名称排序
+type sample struct {
+	Family    string
+	Name      string
+	Help      string
+	Type      dto.MetricType
+	Labels    []labelPair
+	Value     float64
+	Timestamp int64 //毫秒
+}
+
+// flatten 展开 Gather 得到的指标族，没有时间戳的指标使用 now
+func flatten(mfs []*dto.MetricFamily, now time.Time) []sample {
+	var samples []sample
+	nowMs := now.UnixMilli()
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			ts := nowMs
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			labels := make([]labelPair, 0, len(m.GetLabel())+1)
+			for _, l := range m.GetLabel() {
+				labels = append(labels, labelPair{l.GetName(), l.GetValue()})
+			}
+			add := func(suffix string, value float64, extra ...labelPair) {
+				ls := labels
+				if len(extra) > 0 {
+					ls = append(append(make([]labelPair, 0, len(labels)+len(extra)), labels...), extra...)
+				}
+				sort.Slice(ls, func(i, j int) bool { return ls[i].Name < ls[j].Name })
+				samples = append(samples, sample{
+					Family:    mf.GetName(),
+					Name:      mf.GetName() + suffix,
+					Help:      mf.GetHelp(),
+					Type:      mf.GetType(),
+					Labels:    ls,
+					Value:     value,
+					Timestamp: ts,
+				})
+			}
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add("", q.GetValue(), labelPair{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add("_sum", s.GetSampleSum())
+				add("_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				hasInf := false
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						hasInf = true
+					}
+					add("_bucket", float64(b.GetCumulativeCount()), labelPair{"le", formatFloat(b.GetUpperBound())})
+				}
+				if !hasInf {
+					add("_bucket", float64(h.GetSampleCount()), labelPair{"le", "+Inf"})
+				}
+				add("_sum", h.GetSampleSum())
+				add("_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return samples
+}
+
+// withGlobalLabels 补上样本中缺少的 GlobalLabel，部分采集器(比如 net)没有设置 GlobalLabel
+func withGlobalLabels(labels []labelPair) []labelPair {
+	result := append(make([]labelPair, 0, len(labels)+len(collector.GlobalLabel)), labels...)
+	for k, v := range collector.GlobalLabel {
+		exist := false
+		for _, l := range labels {
+			if l.Name == k {
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			result = append(result, labelPair{k, v})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,159 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"m7s.live/engine/v4/log"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type StatsdConfig struct {
+	Addr          string        //StatsD 或 DogStatsD 的 UDP 地址，比如 127.0.0.1:8125，为空不发送
+	Prefix        string        //指标名前缀
+	Interval      time.Duration //发送间隔
+	DogStatsd     bool          //是否以 DogStatsD 标签发送标签，否则标签值会拼接到指标名中
+	MaxPacketSize int           //每个 UDP 包的最大字节数
+}
+
+func (c StatsdConfig) validate() error {
+	return checkPositive("interval", c.Interval)
+}
+
+var (
+	statsdNameSanitizer = regexp.MustCompile(`[^a-zA-Z0-9_.\-]`)
+	statsdTagEscaper    = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+)
+
+type statsdEmitter struct {
+	conf StatsdConfig
+	g    prometheus.Gatherer
+	//累计型指标上次的值，用于转换成增量，只保留最近一次采集中出现的序列
+	last map[string]float64
+}
+
+func newStatsdEmitter(conf StatsdConfig, g prometheus.Gatherer) *statsdEmitter {
+	return &statsdEmitter{
+		conf: conf,
+		g:    g,
+		last: make(map[string]float64),
+	}
+}
+
+// run 按发送间隔定时发送，直到 ctx 取消，连接失败时在下一个间隔重试
+func (e *statsdEmitter) run(ctx context.Context) {
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	ticker := time.NewTicker(e.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if conn == nil {
+				var err error
+				if conn, err = net.Dial("udp", e.conf.Addr); err != nil {
+					log.Warn("Exporter statsd dial err: ", err)
+					continue
+				}
+			}
+			mfs, err := e.g.Gather()
+			if err != nil {
+				log.Warn("Exporter statsd gather err: ", err)
+			}
+			for _, packet := range e.packets(flatten(mfs, now)) {
+				if _, err := conn.Write(packet); err != nil {
+					log.Warn("Exporter statsd write err: ", err)
+					break
+				}
+			}
+		}
+	}
+}
+
+// packets 把样本转换成 StatsD 行并按包大小拆分，计数器和直方图、摘要的 _bucket、_sum、_count 以增量发送
+func (e *statsdEmitter) packets(samples []sample) [][]byte {
+	var packets [][]byte
+	var buf bytes.Buffer
+	//本次没有出现的序列(比如已经关闭的流)不再保留上次的值
+	next := make(map[string]float64, len(e.last))
+	for _, s := range samples {
+		line := e.line(s, next)
+		if line == "" {
+			continue
+		}
+		if buf.Len() > 0 && buf.Len()+1+len(line) > e.conf.MaxPacketSize {
+			packets = append(packets, append([]byte{}, buf.Bytes()...))
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		packets = append(packets, buf.Bytes())
+	}
+	e.last = next
+	return packets
+}
+
+// line 返回样本对应的 StatsD 行，累计型指标的当前值记录到 next 中
+func (e *statsdEmitter) line(s sample, next map[string]float64) string {
+	if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+		return ""
+	}
+	labels := withGlobalLabels(s.Labels)
+	name := e.conf.Prefix + s.Name
+	var tags []string
+	for _, l := range labels {
+		if e.conf.DogStatsd {
+			tags = append(tags, statsdNameSanitizer.ReplaceAllString(l.Name, "_")+":"+statsdTagEscaper.Replace(l.Value))
+		} else {
+			name += "." + statsdNameSanitizer.ReplaceAllString(l.Value, "_")
+		}
+	}
+	value, metricType := s.Value, "g"
+	if statsdCumulative(s) {
+		key := s.Name
+		for _, l := range labels {
+			key += "\xff" + l.Name + "\xff" + l.Value
+		}
+		last, exist := e.last[key]
+		next[key] = s.Value
+		if !exist {
+			return ""
+		}
+		//计数器重置后直接以当前值作为增量
+		if value = s.Value - last; value < 0 {
+			value = s.Value
+		}
+		metricType = "c"
+	}
+	line := statsdNameSanitizer.ReplaceAllString(name, "_") + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + metricType
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+func statsdCumulative(s sample) bool {
+	switch s.Type {
+	case dto.MetricType_COUNTER:
+		return true
+	case dto.MetricType_HISTOGRAM, dto.MetricType_SUMMARY:
+		return s.Name != s.Family
+	}
+	return false
+}
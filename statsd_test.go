@@ -0,0 +1,151 @@
+package exporter
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestStatsdEmitter(t *testing.T) {
+	tests := []struct {
+		name string
+		conf StatsdConfig
+		want []string //前两次发送的行，第一次发送时计数器只记录初始值，不发送
+	}{
+		{
+			name: "dogstatsd",
+			conf: StatsdConfig{DogStatsd: true, MaxPacketSize: 1432},
+			want: []string{
+				"monibuca_streams:3|g|#type:rtmp",
+				"monibuca_bytes_total:5|c|#type:rtmp",
+				"monibuca_streams:3|g|#type:rtmp",
+			},
+		},
+		{
+			name: "plain with prefix",
+			conf: StatsdConfig{Prefix: "m7s.", MaxPacketSize: 1432},
+			want: []string{
+				"m7s.monibuca_streams.rtmp:3|g",
+				"m7s.monibuca_bytes_total.rtmp:5|c",
+				"m7s.monibuca_streams.rtmp:3|g",
+			},
+		},
+		{
+			name: "one line per packet",
+			conf: StatsdConfig{DogStatsd: true, MaxPacketSize: 1},
+			want: []string{
+				"monibuca_streams:3|g|#type:rtmp",
+				"monibuca_bytes_total:5|c|#type:rtmp",
+				"monibuca_streams:3|g|#type:rtmp",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			g := prometheus.NewPedanticRegistry()
+			streams := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "monibuca_streams", Help: "streams"}, []string{"type"})
+			streams.WithLabelValues("rtmp").Set(3)
+			//每次采集增加 5
+			var total float64
+			bytes := prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "monibuca_bytes_total",
+				Help:        "bytes",
+				ConstLabels: prometheus.Labels{"type": "rtmp"},
+			}, func() float64 {
+				total += 5
+				return total
+			})
+			g.MustRegister(streams, bytes)
+
+			conf := tt.conf
+			conf.Addr = conn.LocalAddr().String()
+			conf.Interval = 20 * time.Millisecond
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go newStatsdEmitter(conf, g).run(ctx)
+
+			buf := make([]byte, 65536)
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var lines []string
+			for len(lines) < len(tt.want) {
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				packet := strings.Split(string(buf[:n]), "\n")
+				if len(packet) > 1 && tt.conf.MaxPacketSize == 1 {
+					t.Errorf("packet has %d lines, want 1", len(packet))
+				}
+				lines = append(lines, packet...)
+			}
+			if got := strings.Join(lines[:len(tt.want)], "\n"); got != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", lines, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsdPrunesMissingSeries(t *testing.T) {
+	e := newStatsdEmitter(StatsdConfig{DogStatsd: true, MaxPacketSize: 1432}, nil)
+	bytes := func(stream string, v float64) sample {
+		return sample{Name: "monibuca_bytes_total", Family: "monibuca_bytes_total", Type: dto.MetricType_COUNTER,
+			Labels: []labelPair{{"stream", stream}}, Value: v}
+	}
+	steps := []struct {
+		name    string
+		samples []sample
+		want    string
+		last    int
+	}{
+		{"first gather only records", []sample{bytes("a", 10), bytes("b", 10)}, "", 2},
+		{"b removed", []sample{bytes("a", 15)}, "monibuca_bytes_total:5|c|#stream:a", 1},
+		//b 重新出现时上次的值已经删除，重新作为初始值记录，不会发送和很久以前的值之间的增量
+		{"b back", []sample{bytes("a", 20), bytes("b", 100)}, "monibuca_bytes_total:5|c|#stream:a", 2},
+		{"b delta", []sample{bytes("a", 20), bytes("b", 103)}, "monibuca_bytes_total:0|c|#stream:a\nmonibuca_bytes_total:3|c|#stream:b", 2},
+	}
+	for _, step := range steps {
+		var lines []string
+		for _, packet := range e.packets(step.samples) {
+			lines = append(lines, string(packet))
+		}
+		if got := strings.Join(lines, "\n"); got != step.want {
+			t.Errorf("%s: got %q, want %q", step.name, got, step.want)
+		}
+		if len(e.last) != step.last {
+			t.Errorf("%s: kept %d series, want %d", step.name, len(e.last), step.last)
+		}
+	}
+}
+
+// TestStatsdRunRetriesDial 连接失败时不能退出，之后每个间隔重试直到 ctx 取消
+func TestStatsdRunRetriesDial(t *testing.T) {
+	e := newStatsdEmitter(StatsdConfig{Addr: "missing-port", Interval: 5 * time.Millisecond, MaxPacketSize: 1432}, prometheus.NewRegistry())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("run returned after dial failure")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("run did not return after cancel")
+	}
+}